@@ -8,6 +8,7 @@ import (
 	"github.com/JacksonYuKe/sharedcart-backend/internal/api/routes"
 	"github.com/JacksonYuKe/sharedcart-backend/internal/database"
 	"github.com/JacksonYuKe/sharedcart-backend/internal/models"
+	"github.com/JacksonYuKe/sharedcart-backend/internal/services"
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
@@ -36,6 +37,26 @@ func main() {
 		log.Fatal("Failed to run migrations:", err)
 	}
 
+	// Load exchange rates shipped with the deployment
+	if cfg.ExchangeRates.File != "" {
+		count, err := services.NewExchangeRateService().ImportFile(cfg.ExchangeRates.File)
+		if err != nil {
+			log.Fatal("Failed to load exchange rates:", err)
+		}
+		log.Printf("Loaded %d exchange rates from %s", count, cfg.ExchangeRates.File)
+	}
+
+	// Build running balances for groups whose bills were finalized before balances were kept
+	ledgerService := services.NewLedgerService()
+	billService := services.NewBillService(services.NewGroupService(ledgerService), ledgerService, services.NewExchangeRateService())
+	count, err := billService.BackfillLedger()
+	if err != nil {
+		log.Fatal("Failed to backfill group balances:", err)
+	}
+	if count > 0 {
+		log.Printf("Backfilled balances for %d groups", count)
+	}
+
 	// Set Gin mode
 	gin.SetMode(cfg.Server.Mode)
 
@@ -4,14 +4,17 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
 type Config struct {
-	Database DatabaseConfig
-	Server   ServerConfig
-	JWT      JWTConfig
-	App      AppConfig
+	Database      DatabaseConfig
+	Server        ServerConfig
+	JWT           JWTConfig
+	App           AppConfig
+	Settlement    SettlementConfig
+	ExchangeRates ExchangeRateConfig
 }
 
 type DatabaseConfig struct {
@@ -33,13 +36,23 @@ type ServerConfig struct {
 }
 
 type JWTConfig struct {
-	Secret      string
-	ExpiryHours int
+	Secret            string
+	ExpiryHours       int
+	InviteExpiryHours int // How long group invitation links stay valid
+}
+
+type SettlementConfig struct {
+	ExactSolverMaxMembers int // Above this many outstanding balances, settlements fall back to the greedy matcher
+}
+
+type ExchangeRateConfig struct {
+	File string // Optional CSV of base_currency,quote_currency,rate,effective_date loaded at startup
 }
 
 type AppConfig struct {
 	Name        string
-	Environment string // "development", "staging", "production"
+	Environment string   // "development", "staging", "production"
+	AdminEmails []string // Users allowed to manage app-wide data such as exchange rates
 }
 
 // LoadConfig loads configuration from environment variables
@@ -62,12 +75,20 @@ func LoadConfig() (*Config, error) {
 			Timeout: time.Duration(getEnvAsInt("SERVER_TIMEOUT_SECONDS", 30)) * time.Second,
 		},
 		JWT: JWTConfig{
-			Secret:      getEnv("JWT_SECRET", "your-secret-key-change-this"),
-			ExpiryHours: getEnvAsInt("JWT_EXPIRY_HOURS", 24),
+			Secret:            getEnv("JWT_SECRET", "your-secret-key-change-this"),
+			ExpiryHours:       getEnvAsInt("JWT_EXPIRY_HOURS", 24),
+			InviteExpiryHours: getEnvAsInt("INVITE_EXPIRY_HOURS", 168),
 		},
 		App: AppConfig{
 			Name:        getEnv("APP_NAME", "SharedCart"),
 			Environment: getEnv("ENV", "development"),
+			AdminEmails: getEnvAsSlice("ADMIN_EMAILS"),
+		},
+		Settlement: SettlementConfig{
+			ExactSolverMaxMembers: getEnvAsInt("SETTLEMENT_EXACT_SOLVER_MAX_MEMBERS", 12),
+		},
+		ExchangeRates: ExchangeRateConfig{
+			File: getEnv("EXCHANGE_RATES_FILE", ""),
 		},
 	}
 
@@ -100,3 +121,13 @@ func getEnvAsInt(key string, defaultValue int) int {
 	}
 	return defaultValue
 }
+
+func getEnvAsSlice(key string) []string {
+	var values []string
+	for _, value := range strings.Split(getEnv(key, ""), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
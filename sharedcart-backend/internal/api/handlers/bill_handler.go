@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
 
@@ -40,6 +41,10 @@ func (h *BillHandler) CreateBill(c *gin.Context) {
 		switch err.Error() {
 		case "user is not a member of this group":
 			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		case "payer is not a member of this group":
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case "group not found":
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
 		default:
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		}
@@ -318,12 +323,20 @@ func (h *BillHandler) FinalizeBill(c *gin.Context) {
 
 	err = h.billService.FinalizeBill(uint(billID), userID)
 	if err != nil {
+		var missingRate *services.MissingExchangeRateError
+		if errors.As(err, &missingRate) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error(), "missing_rate": missingRate})
+			return
+		}
+
 		switch err.Error() {
 		case "bill not found":
 			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
 		case "only bill creator or group admin can finalize the bill",
 			"cannot finalize bill without items":
 			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		case "bill is not pending":
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
 		default:
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		}
@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/JacksonYuKe/sharedcart-backend/internal/api/middleware"
+	"github.com/JacksonYuKe/sharedcart-backend/internal/models"
+	"github.com/JacksonYuKe/sharedcart-backend/internal/services"
+	"github.com/gin-gonic/gin"
+)
+
+// DebtOffsetHandler handles cross-group balance and debt offset endpoints
+type DebtOffsetHandler struct {
+	debtOffsetService *services.DebtOffsetService
+}
+
+// NewDebtOffsetHandler creates a new debt offset handler
+func NewDebtOffsetHandler(debtOffsetService *services.DebtOffsetService) *DebtOffsetHandler {
+	return &DebtOffsetHandler{
+		debtOffsetService: debtOffsetService,
+	}
+}
+
+// GetUserBalances returns the current user's balances with everyone across all their groups
+func (h *DebtOffsetHandler) GetUserBalances(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
+		return
+	}
+
+	result, err := h.debtOffsetService.GetUserBalances(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"balances": result})
+}
+
+// GetDebtOffsets lists the current user's debt offsets
+func (h *DebtOffsetHandler) GetDebtOffsets(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
+		return
+	}
+
+	offsets, err := h.debtOffsetService.GetDebtOffsets(userID, c.Query("status"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"debt_offsets": offsets})
+}
+
+// ProposeDebtOffset proposes cancelling a debt in one group against a credit in another
+func (h *DebtOffsetHandler) ProposeDebtOffset(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
+		return
+	}
+
+	var req services.ProposeDebtOffsetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	offset, err := h.debtOffsetService.ProposeDebtOffset(userID, req)
+	if err != nil {
+		switch err.Error() {
+		case "cannot offset debts with yourself",
+			"debt and credit groups must differ",
+			"debt and credit groups use different currencies",
+			"no offsettable debts between these groups",
+			"offset amount must be positive",
+			"offset exceeds offsettable amount":
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case "an offset between these groups is already pending",
+			"unpaid settlement transactions exist between these members":
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"debt_offset": offset})
+}
+
+// AcceptDebtOffset accepts a debt offset and records it in both groups
+func (h *DebtOffsetHandler) AcceptDebtOffset(c *gin.Context) {
+	h.respondToDebtOffset(c, h.debtOffsetService.AcceptDebtOffset)
+}
+
+// DeclineDebtOffset declines a debt offset
+func (h *DebtOffsetHandler) DeclineDebtOffset(c *gin.Context) {
+	h.respondToDebtOffset(c, h.debtOffsetService.DeclineDebtOffset)
+}
+
+// respondToDebtOffset handles the shared request flow for accepting and declining offsets
+func (h *DebtOffsetHandler) respondToDebtOffset(c *gin.Context, respond func(uint, uint) (*models.DebtOffset, error)) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
+		return
+	}
+
+	offsetID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid debt offset ID"})
+		return
+	}
+
+	offset, err := respond(uint(offsetID), userID)
+	if err != nil {
+		switch err.Error() {
+		case "debt offset not found":
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case "only the counterparty can respond to a debt offset":
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		case "debt offset is not pending",
+			"debt offset is no longer valid",
+			"unpaid settlement transactions exist between these members":
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"debt_offset": offset})
+}
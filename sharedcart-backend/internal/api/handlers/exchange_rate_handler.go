@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/JacksonYuKe/sharedcart-backend/internal/services"
+	"github.com/gin-gonic/gin"
+)
+
+// ExchangeRateHandler handles exchange rate endpoints
+type ExchangeRateHandler struct {
+	exchangeRateService *services.ExchangeRateService
+}
+
+// NewExchangeRateHandler creates a new exchange rate handler
+func NewExchangeRateHandler(exchangeRateService *services.ExchangeRateService) *ExchangeRateHandler {
+	return &ExchangeRateHandler{
+		exchangeRateService: exchangeRateService,
+	}
+}
+
+// GetExchangeRates lists exchange rates, optionally filtered by ?base=EUR&quote=USD
+func (h *ExchangeRateHandler) GetExchangeRates(c *gin.Context) {
+	rates, err := h.exchangeRateService.GetExchangeRates(c.Query("base"), c.Query("quote"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"exchange_rates": rates})
+}
+
+// SetExchangeRates creates or replaces a batch of exchange rates
+func (h *ExchangeRateHandler) SetExchangeRates(c *gin.Context) {
+	var req services.SetExchangeRatesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	rates, err := h.exchangeRateService.SetExchangeRates(req.Rates)
+	if err != nil {
+		switch err.Error() {
+		case "base and quote currency must differ",
+			"exchange rate must be positive",
+			"effective date must be formatted as YYYY-MM-DD":
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"exchange_rates": rates})
+}
+
+// DeleteExchangeRate removes an exchange rate
+func (h *ExchangeRateHandler) DeleteExchangeRate(c *gin.Context) {
+	rateID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid exchange rate ID"})
+		return
+	}
+
+	if err := h.exchangeRateService.DeleteExchangeRate(uint(rateID)); err != nil {
+		switch err.Error() {
+		case "exchange rate not found":
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "exchange rate deleted successfully"})
+}
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"fmt"
 	"net/http"
 	"strconv"
 
@@ -122,6 +123,10 @@ func (h *GroupHandler) UpdateGroup(c *gin.Context) {
 			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
 			return
 		}
+		if err.Error() == "cannot change currency after bills are finalized" {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -183,7 +188,9 @@ func (h *GroupHandler) GetGroupMembers(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"members": members})
 }
 
-// AddMember adds a new member to the group
+// AddMember adds a new member to the group directly.
+//
+// Deprecated: use the group invitation endpoints, so people agree to join.
 func (h *GroupHandler) AddMember(c *gin.Context) {
 	userID, exists := middleware.GetUserID(c)
 	if !exists {
@@ -197,6 +204,10 @@ func (h *GroupHandler) AddMember(c *gin.Context) {
 		return
 	}
 
+	// Point clients at the invitation endpoint that replaces this one
+	c.Header("Deprecation", "true")
+	c.Header("Link", fmt.Sprintf("</api/v1/groups/%d/invitations>; rel=\"successor-version\"", groupID))
+
 	var req services.AddMemberRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
@@ -221,6 +232,39 @@ func (h *GroupHandler) AddMember(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "member added successfully"})
 }
 
+// AddPlaceholder adds a participant without an account to the group
+func (h *GroupHandler) AddPlaceholder(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
+		return
+	}
+
+	groupID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid group ID"})
+		return
+	}
+
+	var req services.AddPlaceholderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	member, err := h.groupService.AddPlaceholder(uint(groupID), userID, req)
+	if err != nil {
+		if err.Error() == "user is not a member of this group" {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"member": member})
+}
+
 // RemoveMember removes a member from the group
 func (h *GroupHandler) RemoveMember(c *gin.Context) {
 	userID, exists := middleware.GetUserID(c)
@@ -241,7 +285,13 @@ func (h *GroupHandler) RemoveMember(c *gin.Context) {
 		return
 	}
 
-	err = h.groupService.RemoveMember(uint(groupID), userID, uint(memberID))
+	var req services.RemoveMemberRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err = h.groupService.RemoveMember(uint(groupID), userID, uint(memberID), req)
 	if err != nil {
 		switch err.Error() {
 		case "only group admins can remove members",
@@ -249,6 +299,14 @@ func (h *GroupHandler) RemoveMember(c *gin.Context) {
 			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
 		case "member not found in group":
 			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case "reassign_to requires force",
+			"reassign target must be another member of the group":
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case "member has an outstanding balance",
+			"member has unpaid settlement transactions",
+			"member is on bills that are not finalized",
+			"no members left to take on the balance":
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
 		default:
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		}
@@ -258,6 +316,38 @@ func (h *GroupHandler) RemoveMember(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "member removed successfully"})
 }
 
+// LeaveGroup lets the authenticated user leave a group
+func (h *GroupHandler) LeaveGroup(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
+		return
+	}
+
+	groupID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid group ID"})
+		return
+	}
+
+	result, err := h.groupService.LeaveGroup(uint(groupID), userID)
+	if err != nil {
+		switch err.Error() {
+		case "user is not a member of this group":
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case "member has an outstanding balance",
+			"member has unpaid settlement transactions",
+			"member is on bills that are not finalized":
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "left group successfully", "result": result})
+}
+
 // UpdateMemberRole updates a member's role in the group
 func (h *GroupHandler) UpdateMemberRole(c *gin.Context) {
 	userID, exists := middleware.GetUserID(c)
@@ -292,6 +382,8 @@ func (h *GroupHandler) UpdateMemberRole(c *gin.Context) {
 			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
 		case "member not found in group":
 			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case "placeholders cannot be admins":
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		default:
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		}
@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/JacksonYuKe/sharedcart-backend/internal/api/middleware"
+	"github.com/JacksonYuKe/sharedcart-backend/internal/services"
+	"github.com/gin-gonic/gin"
+)
+
+// InvitationHandler handles group invitation endpoints
+type InvitationHandler struct {
+	invitationService *services.InvitationService
+}
+
+// NewInvitationHandler creates a new invitation handler
+func NewInvitationHandler(invitationService *services.InvitationService) *InvitationHandler {
+	return &InvitationHandler{
+		invitationService: invitationService,
+	}
+}
+
+// CreateInvitation invites someone to a group by email
+func (h *InvitationHandler) CreateInvitation(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
+		return
+	}
+
+	groupID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid group ID"})
+		return
+	}
+
+	var req services.CreateInvitationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result, err := h.invitationService.CreateInvitation(uint(groupID), userID, req)
+	if err != nil {
+		switch err.Error() {
+		case "only group admins can invite members":
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		case "placeholder not found in group":
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case "user is already a member of this group",
+			"user has already been a member of this group":
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":    "invitation sent successfully",
+		"invitation": result.Invitation,
+		"token":      result.Token,
+	})
+}
+
+// GetGroupInvitations lists a group's invitations
+func (h *InvitationHandler) GetGroupInvitations(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
+		return
+	}
+
+	groupID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid group ID"})
+		return
+	}
+
+	invitations, err := h.invitationService.GetGroupInvitations(uint(groupID), userID, c.Query("status"))
+	if err != nil {
+		if err.Error() == "only group admins can view invitations" {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"invitations": invitations})
+}
+
+// RevokeInvitation withdraws a pending invitation
+func (h *InvitationHandler) RevokeInvitation(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
+		return
+	}
+
+	groupID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid group ID"})
+		return
+	}
+
+	invitationID, err := strconv.ParseUint(c.Param("invitationId"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid invitation ID"})
+		return
+	}
+
+	err = h.invitationService.RevokeInvitation(uint(groupID), uint(invitationID), userID)
+	if err != nil {
+		switch err.Error() {
+		case "only group admins can revoke invitations":
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		case "invitation not found":
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case "invitation is no longer pending":
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "invitation revoked successfully"})
+}
+
+// GetUserInvitations lists the authenticated user's pending invitations
+func (h *InvitationHandler) GetUserInvitations(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
+		return
+	}
+
+	invitations, err := h.invitationService.GetUserInvitations(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"invitations": invitations})
+}
+
+// AcceptInvitation joins the group an invitation token is for
+func (h *InvitationHandler) AcceptInvitation(c *gin.Context) {
+	h.respond(c, true)
+}
+
+// DeclineInvitation turns down the invitation a token is for
+func (h *InvitationHandler) DeclineInvitation(c *gin.Context) {
+	h.respond(c, false)
+}
+
+func (h *InvitationHandler) respond(c *gin.Context, accept bool) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
+		return
+	}
+
+	var req services.RespondInvitationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	respond := h.invitationService.DeclineInvitation
+	if accept {
+		respond = h.invitationService.AcceptInvitation
+	}
+
+	invitation, err := respond(userID, req)
+	if err != nil {
+		switch err.Error() {
+		case "invalid or expired invitation token":
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case "invitation was sent to a different email":
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		case "invitation not found", "group not found", "placeholder not found in group":
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case "invitation is no longer pending",
+			"user has already been a member of this group":
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"invitation": invitation})
+}
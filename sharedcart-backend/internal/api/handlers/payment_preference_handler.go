@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/JacksonYuKe/sharedcart-backend/internal/api/middleware"
+	"github.com/JacksonYuKe/sharedcart-backend/internal/services"
+	"github.com/gin-gonic/gin"
+)
+
+// PaymentPreferenceHandler handles payment preference endpoints
+type PaymentPreferenceHandler struct {
+	preferenceService *services.PaymentPreferenceService
+}
+
+// NewPaymentPreferenceHandler creates a new payment preference handler
+func NewPaymentPreferenceHandler(preferenceService *services.PaymentPreferenceService) *PaymentPreferenceHandler {
+	return &PaymentPreferenceHandler{
+		preferenceService: preferenceService,
+	}
+}
+
+// GetPaymentPreferences lists the current user's payment preferences in a group
+func (h *PaymentPreferenceHandler) GetPaymentPreferences(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
+		return
+	}
+
+	groupID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid group ID"})
+		return
+	}
+
+	preferences, err := h.preferenceService.GetPaymentPreferences(uint(groupID), userID)
+	if err != nil {
+		switch err.Error() {
+		case "user is not a member of this group":
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"payment_preferences": preferences})
+}
+
+// SetPaymentPreference creates or replaces a preference for paying another member
+func (h *PaymentPreferenceHandler) SetPaymentPreference(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
+		return
+	}
+
+	groupID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid group ID"})
+		return
+	}
+
+	var req services.SetPaymentPreferenceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	preference, err := h.preferenceService.SetPaymentPreference(uint(groupID), userID, req)
+	if err != nil {
+		switch err.Error() {
+		case "user is not a member of this group":
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		case "cannot set a payment preference for yourself",
+			"target user is not a member of this group":
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"payment_preference": preference})
+}
+
+// DeletePaymentPreference removes a preference for paying another member
+func (h *PaymentPreferenceHandler) DeletePaymentPreference(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
+		return
+	}
+
+	groupID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid group ID"})
+		return
+	}
+
+	targetUserID, err := strconv.ParseUint(c.Param("targetUserId"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user ID"})
+		return
+	}
+
+	err = h.preferenceService.DeletePaymentPreference(uint(groupID), userID, uint(targetUserID))
+	if err != nil {
+		switch err.Error() {
+		case "user is not a member of this group":
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		case "payment preference not found":
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "payment preference deleted successfully"})
+}
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
 
@@ -37,11 +38,29 @@ func (h *SettlementHandler) CalculateSettlement(c *gin.Context) {
 
 	result, err := h.settlementService.CalculateSettlement(userID, req)
 	if err != nil {
+		var conflict *services.BillConflictError
+		if errors.As(err, &conflict) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "conflicts": conflict})
+			return
+		}
+		var missingRate *services.MissingExchangeRateError
+		if errors.As(err, &missingRate) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error(), "missing_rate": missingRate})
+			return
+		}
+
 		switch err.Error() {
 		case "user is not a member of this group":
 			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
-		case "no bills found":
+		case "bill_ids are required when no mode is given",
+			"bill_ids cannot be combined with a mode",
+			"date_range mode requires from and to",
+			"from must not be after to":
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case "no bills found", "group not found":
 			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case "payment preferences make settlement impossible":
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
 		default:
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		}
@@ -68,11 +87,29 @@ func (h *SettlementHandler) CreateSettlement(c *gin.Context) {
 	// First calculate the settlement
 	result, err := h.settlementService.CalculateSettlement(userID, req)
 	if err != nil {
+		var conflict *services.BillConflictError
+		if errors.As(err, &conflict) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "conflicts": conflict})
+			return
+		}
+		var missingRate *services.MissingExchangeRateError
+		if errors.As(err, &missingRate) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error(), "missing_rate": missingRate})
+			return
+		}
+
 		switch err.Error() {
 		case "user is not a member of this group":
 			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
-		case "no bills found":
+		case "bill_ids are required when no mode is given",
+			"bill_ids cannot be combined with a mode",
+			"date_range mode requires from and to",
+			"from must not be after to":
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case "no bills found", "group not found":
 			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case "payment preferences make settlement impossible":
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
 		default:
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		}
@@ -82,6 +119,11 @@ func (h *SettlementHandler) CreateSettlement(c *gin.Context) {
 	// Then save it
 	settlement, err := h.settlementService.CreateSettlement(userID, req, result)
 	if err != nil {
+		var conflict *services.BillConflictError
+		if errors.As(err, &conflict) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "conflicts": conflict})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -122,6 +164,47 @@ func (h *SettlementHandler) GetSettlement(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"settlement": settlement})
 }
 
+// ExportSettlement downloads a settlement statement as csv or pdf
+func (h *SettlementHandler) ExportSettlement(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
+		return
+	}
+
+	settlementID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid settlement ID"})
+		return
+	}
+
+	export, err := h.settlementService.ExportSettlement(uint(settlementID), userID, c.DefaultQuery("format", "csv"))
+	if err != nil {
+		var missingRate *services.MissingExchangeRateError
+		if errors.As(err, &missingRate) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error(), "missing_rate": missingRate})
+			return
+		}
+
+		switch err.Error() {
+		case "format must be csv or pdf":
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case "settlement not found":
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case "user is not authorized to view this settlement":
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		case "payment preferences make settlement impossible":
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.Header("Content-Disposition", "attachment; filename="+export.FileName)
+	c.Data(http.StatusOK, export.ContentType, export.Data)
+}
+
 // GetGroupSettlements retrieves all settlements for a group
 func (h *SettlementHandler) GetGroupSettlements(c *gin.Context) {
 	userID, exists := middleware.GetUserID(c)
@@ -190,3 +273,217 @@ func (h *SettlementHandler) ConfirmSettlement(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{"message": "settlement confirmed successfully"})
 }
+
+// GetGroupBalances retrieves the running balances of a group
+func (h *SettlementHandler) GetGroupBalances(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
+		return
+	}
+
+	groupID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid group ID"})
+		return
+	}
+
+	result, err := h.settlementService.GetGroupBalances(uint(groupID), userID)
+	if err != nil {
+		switch err.Error() {
+		case "user is not a member of this group":
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		case "group not found":
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"balances": result})
+}
+
+// CancelSettlement cancels a pending settlement
+func (h *SettlementHandler) CancelSettlement(c *gin.Context) {
+	h.revokeSettlement(c, h.settlementService.CancelSettlement, "settlement cancelled successfully")
+}
+
+// VoidSettlement voids a confirmed settlement
+func (h *SettlementHandler) VoidSettlement(c *gin.Context) {
+	h.revokeSettlement(c, h.settlementService.VoidSettlement, "settlement voided successfully")
+}
+
+// revokeSettlement handles the shared request flow for cancelling and voiding settlements
+func (h *SettlementHandler) revokeSettlement(c *gin.Context, revoke func(uint, uint, services.CancelSettlementRequest) error, message string) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
+		return
+	}
+
+	settlementID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid settlement ID"})
+		return
+	}
+
+	var req services.CancelSettlementRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err = revoke(uint(settlementID), userID, req)
+	if err != nil {
+		switch err.Error() {
+		case "settlement not found":
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case "only settlement creator or group admin can cancel settlement",
+			"only settlement creator or group admin can void settlement",
+			"settlement is not pending",
+			"settlement is not confirmed":
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": message})
+}
+
+// RecordPayment records a payment towards a settlement transaction
+func (h *SettlementHandler) RecordPayment(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
+		return
+	}
+
+	settlementID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid settlement ID"})
+		return
+	}
+
+	transactionID, err := strconv.ParseUint(c.Param("transactionId"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid transaction ID"})
+		return
+	}
+
+	var req services.RecordPaymentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	transaction, err := h.settlementService.RecordPayment(uint(settlementID), uint(transactionID), userID, req)
+	if err != nil {
+		switch err.Error() {
+		case "settlement not found", "transaction not found":
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case "only the payer can record a payment",
+			"settlement is not open for payments":
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		case "payment amount must be positive",
+			"payment exceeds remaining amount":
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"transaction": transaction})
+}
+
+// SetPaymentCurrency chooses the currency a settlement transaction will be paid in
+func (h *SettlementHandler) SetPaymentCurrency(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
+		return
+	}
+
+	settlementID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid settlement ID"})
+		return
+	}
+
+	transactionID, err := strconv.ParseUint(c.Param("transactionId"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid transaction ID"})
+		return
+	}
+
+	var req services.SetPaymentCurrencyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	transaction, err := h.settlementService.SetPaymentCurrency(uint(settlementID), uint(transactionID), userID, req)
+	if err != nil {
+		var missingRate *services.MissingExchangeRateError
+		if errors.As(err, &missingRate) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error(), "missing_rate": missingRate})
+			return
+		}
+
+		switch err.Error() {
+		case "settlement not found", "transaction not found":
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case "only the payer can choose the payment currency",
+			"settlement is not open for payments":
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		case "cannot change payment currency after payments are recorded":
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"transaction": transaction})
+}
+
+// AcknowledgePayment confirms receipt of a settlement transaction's payments
+func (h *SettlementHandler) AcknowledgePayment(c *gin.Context) {
+	userID, exists := middleware.GetUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
+		return
+	}
+
+	settlementID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid settlement ID"})
+		return
+	}
+
+	transactionID, err := strconv.ParseUint(c.Param("transactionId"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid transaction ID"})
+		return
+	}
+
+	transaction, err := h.settlementService.AcknowledgePayment(uint(settlementID), uint(transactionID), userID)
+	if err != nil {
+		switch err.Error() {
+		case "settlement not found", "transaction not found":
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case "only the receiver can acknowledge a payment",
+			"settlement is not open for payments",
+			"no payment to acknowledge":
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"transaction": transaction})
+}
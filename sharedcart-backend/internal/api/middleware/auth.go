@@ -2,6 +2,7 @@ package middleware
 
 import (
 	"net/http"
+	"slices"
 	"strings"
 
 	"github.com/JacksonYuKe/sharedcart-backend/config"
@@ -72,3 +73,20 @@ func GetUserEmail(c *gin.Context) (string, bool) {
 
 	return "", false
 }
+
+// AdminMiddleware only lets through users whose email is in the configured admin list
+func AdminMiddleware(adminEmails []string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		email, exists := GetUserEmail(c)
+		isAdmin := slices.ContainsFunc(adminEmails, func(admin string) bool {
+			return strings.EqualFold(admin, email)
+		})
+		if !exists || !isAdmin {
+			c.JSON(http.StatusForbidden, gin.H{"error": "admin access required"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
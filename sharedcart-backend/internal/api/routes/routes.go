@@ -12,15 +12,24 @@ import (
 func SetupRoutes(router *gin.Engine, cfg *config.Config) {
 	// Initialize services
 	authService := services.NewAuthService(&cfg.JWT)
-	groupService := services.NewGroupService()
-	billService := services.NewBillService(groupService)
-	settlementService := services.NewSettlementService(groupService, billService)
+	ledgerService := services.NewLedgerService()
+	groupService := services.NewGroupService(ledgerService)
+	exchangeRateService := services.NewExchangeRateService()
+	billService := services.NewBillService(groupService, ledgerService, exchangeRateService)
+	preferenceService := services.NewPaymentPreferenceService(groupService)
+	settlementService := services.NewSettlementService(&cfg.Settlement, groupService, billService, ledgerService, preferenceService, exchangeRateService)
+	debtOffsetService := services.NewDebtOffsetService(groupService, settlementService, ledgerService)
+	invitationService := services.NewInvitationService(&cfg.JWT, groupService)
 
 	// Initialize handlers
 	authHandler := handlers.NewAuthHandler(authService)
 	groupHandler := handlers.NewGroupHandler(groupService)
 	billHandler := handlers.NewBillHandler(billService)
 	settlementHandler := handlers.NewSettlementHandler(settlementService)
+	preferenceHandler := handlers.NewPaymentPreferenceHandler(preferenceService)
+	exchangeRateHandler := handlers.NewExchangeRateHandler(exchangeRateService)
+	debtOffsetHandler := handlers.NewDebtOffsetHandler(debtOffsetService)
+	invitationHandler := handlers.NewInvitationHandler(invitationService)
 
 	// Health check endpoint (removed duplicate - handled elsewhere)
 
@@ -41,6 +50,7 @@ func SetupRoutes(router *gin.Engine, cfg *config.Config) {
 		{
 			// User routes
 			protected.GET("/profile", authHandler.GetProfile)
+			protected.GET("/balances", debtOffsetHandler.GetUserBalances) // Across all of the user's groups
 
 			// Group routes
 			groups := protected.Group("/groups")
@@ -53,9 +63,24 @@ func SetupRoutes(router *gin.Engine, cfg *config.Config) {
 
 				// Group member routes
 				groups.GET("/:id/members", groupHandler.GetGroupMembers)
-				groups.POST("/:id/members", groupHandler.AddMember)
-				groups.DELETE("/:id/members/:userId", groupHandler.RemoveMember)
+				groups.POST("/:id/members", groupHandler.AddMember)              // Deprecated: invite through /:id/invitations instead
+				groups.DELETE("/:id/members/:userId", groupHandler.RemoveMember) // ?force=true&reassign_to=5 for members with a balance
 				groups.PUT("/:id/members/:userId/role", groupHandler.UpdateMemberRole)
+				groups.POST("/:id/leave", groupHandler.LeaveGroup)
+				groups.POST("/:id/placeholders", groupHandler.AddPlaceholder) // Participants without an account, claimed through an invitation
+
+				// Group invitation routes
+				groups.GET("/:id/invitations", invitationHandler.GetGroupInvitations) // ?status=pending
+				groups.POST("/:id/invitations", invitationHandler.CreateInvitation)
+				groups.DELETE("/:id/invitations/:invitationId", invitationHandler.RevokeInvitation)
+
+				// Group balance routes
+				groups.GET("/:id/balances", settlementHandler.GetGroupBalances)
+
+				// Payment preference routes
+				groups.GET("/:id/payment-preferences", preferenceHandler.GetPaymentPreferences)
+				groups.PUT("/:id/payment-preferences", preferenceHandler.SetPaymentPreference)
+				groups.DELETE("/:id/payment-preferences/:targetUserId", preferenceHandler.DeletePaymentPreference)
 			}
 
 			// Bill routes
@@ -81,7 +106,43 @@ func SetupRoutes(router *gin.Engine, cfg *config.Config) {
 				settlements.POST("", settlementHandler.CreateSettlement)
 				settlements.GET("", settlementHandler.GetGroupSettlements) // ?group_id=1&status=pending
 				settlements.GET("/:id", settlementHandler.GetSettlement)
+				settlements.GET("/:id/export", settlementHandler.ExportSettlement) // ?format=csv|pdf
 				settlements.POST("/:id/confirm", settlementHandler.ConfirmSettlement)
+				settlements.POST("/:id/cancel", settlementHandler.CancelSettlement)
+				settlements.POST("/:id/void", settlementHandler.VoidSettlement)
+
+				// Settlement transaction routes
+				settlements.PUT("/:id/transactions/:transactionId/payment-currency", settlementHandler.SetPaymentCurrency)
+				settlements.POST("/:id/transactions/:transactionId/payments", settlementHandler.RecordPayment)
+				settlements.POST("/:id/transactions/:transactionId/acknowledge", settlementHandler.AcknowledgePayment)
+			}
+
+			// Debt offset routes
+			debtOffsets := protected.Group("/debt-offsets")
+			{
+				debtOffsets.GET("", debtOffsetHandler.GetDebtOffsets) // ?status=pending
+				debtOffsets.POST("", debtOffsetHandler.ProposeDebtOffset)
+				debtOffsets.POST("/:id/accept", debtOffsetHandler.AcceptDebtOffset)
+				debtOffsets.POST("/:id/decline", debtOffsetHandler.DeclineDebtOffset)
+			}
+
+			// Invitation routes for the invitee
+			invitations := protected.Group("/invitations")
+			{
+				invitations.GET("", invitationHandler.GetUserInvitations)
+				invitations.POST("/accept", invitationHandler.AcceptInvitation)
+				invitations.POST("/decline", invitationHandler.DeclineInvitation)
+			}
+
+			// Exchange rate routes
+			protected.GET("/exchange-rates", exchangeRateHandler.GetExchangeRates) // ?base=EUR&quote=USD
+
+			// Admin routes
+			admin := protected.Group("/admin")
+			admin.Use(middleware.AdminMiddleware(cfg.App.AdminEmails))
+			{
+				admin.POST("/exchange-rates", exchangeRateHandler.SetExchangeRates)
+				admin.DELETE("/exchange-rates/:id", exchangeRateHandler.DeleteExchangeRate)
 			}
 		}
 	}
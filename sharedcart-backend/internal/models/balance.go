@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// GroupBalance represents a member's running balance in a group
+type GroupBalance struct {
+	ID        uint            `gorm:"primaryKey" json:"id"`
+	GroupID   uint            `gorm:"not null;uniqueIndex:idx_group_balances_group_user" json:"group_id"`
+	UserID    uint            `gorm:"not null;uniqueIndex:idx_group_balances_group_user" json:"user_id"`
+	Balance   decimal.Decimal `gorm:"type:decimal(12,2);not null;default:0" json:"balance"` // Positive means the user should receive
+	UpdatedAt time.Time       `json:"updated_at"`
+
+	// Relationships
+	User *User `gorm:"foreignKey:UserID" json:"user,omitempty"`
+}
+
+// TableName specifies the table name for GroupBalance model
+func (GroupBalance) TableName() string {
+	return "group_balances"
+}
@@ -15,8 +15,11 @@ type Bill struct {
 	Title       string          `gorm:"not null" json:"title"`
 	Description string          `json:"description,omitempty"`
 	TotalAmount decimal.Decimal `gorm:"type:decimal(10,2);not null" json:"total_amount"`
+	Currency    string          `gorm:"size:3;not null;default:'USD'" json:"currency"` // ISO 4217 code, defaults to the group currency
 	PaidByID    uint            `gorm:"not null" json:"paid_by_id"`
 	PaidBy      *User           `gorm:"foreignKey:PaidByID" json:"paid_by,omitempty"`
+	CreatedByID uint            `gorm:"index" json:"created_by_id"` // Who entered the bill, may differ from the payer
+	CreatedBy   *User           `gorm:"foreignKey:CreatedByID" json:"created_by,omitempty"`
 	BillDate    time.Time       `gorm:"not null" json:"bill_date"`
 	Status      string          `gorm:"default:'pending'" json:"status"` // pending, finalized, settled
 	CreatedAt   time.Time       `json:"created_at"`
@@ -24,7 +27,32 @@ type Bill struct {
 	DeletedAt   gorm.DeletedAt  `gorm:"index" json:"-"`
 
 	// Relationships
-	Items []BillItem `gorm:"foreignKey:BillID" json:"items,omitempty"`
+	Items       []BillItem       `gorm:"foreignKey:BillID" json:"items,omitempty"`
+	Payers      []BillPayer      `gorm:"foreignKey:BillID" json:"payers,omitempty"` // Set when the bill was paid by several people
+	Adjustments []BillAdjustment `gorm:"foreignKey:BillID" json:"adjustments,omitempty"`
+}
+
+// BillAdjustment represents a bill-level charge or credit that isn't a line item,
+// such as tax, tip, a delivery fee or a coupon
+type BillAdjustment struct {
+	ID           uint            `gorm:"primaryKey" json:"id"`
+	BillID       uint            `gorm:"not null;index" json:"bill_id"`
+	Type         string          `gorm:"not null" json:"type"` // tax, tip, fee, discount
+	Name         string          `json:"name,omitempty"`
+	Amount       decimal.Decimal `gorm:"type:decimal(10,2);not null" json:"amount"` // Always positive; discounts are subtracted
+	SplitEqually bool            `json:"split_equally"`                             // Split equally instead of by item subtotal
+	CreatedAt    time.Time       `json:"created_at"`
+}
+
+// BillPayer represents one person's contribution to paying a bill
+type BillPayer struct {
+	ID     uint            `gorm:"primaryKey" json:"id"`
+	BillID uint            `gorm:"not null;index" json:"bill_id"`
+	UserID uint            `gorm:"not null" json:"user_id"`
+	Amount decimal.Decimal `gorm:"type:decimal(10,2);not null" json:"amount"`
+
+	// Relationships
+	User *User `gorm:"foreignKey:UserID" json:"user,omitempty"`
 }
 
 // BillItem represents an item in a bill
@@ -35,13 +63,16 @@ type BillItem struct {
 	Name        string          `gorm:"not null" json:"name"`
 	Description string          `json:"description,omitempty"`
 	Amount      decimal.Decimal `gorm:"type:decimal(10,2);not null" json:"amount"`
-	Quantity    int             `gorm:"default:1" json:"quantity"`
+	Quantity    decimal.Decimal `gorm:"type:decimal(10,3);default:1" json:"quantity"`
+	Unit        string          `gorm:"default:'each'" json:"unit"` // kg, lb, L, each
 	IsShared    bool            `json:"is_shared"`
+	SplitMode   string          `gorm:"default:'equal'" json:"split_mode"` // equal, shares, percent, exact
 	CreatedAt   time.Time       `json:"created_at"`
 	UpdatedAt   time.Time       `json:"updated_at"`
 
 	// Relationships
-	Owners []User `gorm:"many2many:item_owners;foreignKey:ID;joinForeignKey:ItemID;References:ID;joinReferences:UserID" json:"owners,omitempty"`
+	Owners     []User      `gorm:"many2many:item_owners;foreignKey:ID;joinForeignKey:ItemID;References:ID;joinReferences:UserID" json:"owners,omitempty"`
+	ItemOwners []ItemOwner `gorm:"foreignKey:ItemID" json:"item_owners,omitempty"` // Owner rows including share ratios
 }
 
 // ItemOwner represents the join table for items and their owners
@@ -49,7 +80,8 @@ type ItemOwner struct {
 	ID         uint            `gorm:"primaryKey" json:"id"`
 	ItemID     uint            `gorm:"not null" json:"item_id"`
 	UserID     uint            `gorm:"not null" json:"user_id"`
-	ShareRatio decimal.Decimal `gorm:"type:decimal(5,2);default:1.00" json:"share_ratio"` // For custom split ratios
+	ShareRatio decimal.Decimal `gorm:"type:decimal(5,2);default:1.00" json:"share_ratio"` // For custom split ratios, or the percentage in percent mode
+	Amount     decimal.Decimal `gorm:"type:decimal(10,2);default:0" json:"amount"`        // Fixed amount in exact mode
 
 	// Relationships
 	Item *BillItem `gorm:"foreignKey:ItemID;references:ID" json:"item,omitempty"`
@@ -71,6 +103,24 @@ func (ItemOwner) TableName() string {
 	return "item_owners"
 }
 
+// TableName specifies the table name for BillPayer model
+func (BillPayer) TableName() string {
+	return "bill_payers"
+}
+
+// TableName specifies the table name for BillAdjustment model
+func (BillAdjustment) TableName() string {
+	return "bill_adjustments"
+}
+
+// SignedAmount returns the adjustment's effect on the bill total
+func (ba *BillAdjustment) SignedAmount() decimal.Decimal {
+	if ba.Type == "discount" {
+		return ba.Amount.Neg()
+	}
+	return ba.Amount
+}
+
 // BeforeCreate hooks
 func (b *Bill) BeforeCreate(tx *gorm.DB) error {
 	b.CreatedAt = time.Now()
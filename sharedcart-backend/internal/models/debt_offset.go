@@ -0,0 +1,49 @@
+package models
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+// DebtOffset represents a proposal to cancel what one member owes another in one group
+// against what the other owes them in a second group. It only touches the balances once the
+// counterparty accepts.
+type DebtOffset struct {
+	ID             uint            `gorm:"primaryKey" json:"id"`
+	ProposerID     uint            `gorm:"not null;index" json:"proposer_id"`
+	CounterpartyID uint            `gorm:"not null;index" json:"counterparty_id"`
+	DebtGroupID    uint            `gorm:"not null" json:"debt_group_id"`   // Group where the proposer owes the counterparty
+	CreditGroupID  uint            `gorm:"not null" json:"credit_group_id"` // Group where the counterparty owes the proposer
+	Currency       string          `gorm:"size:3;not null" json:"currency"`
+	Amount         decimal.Decimal `gorm:"type:decimal(10,2);not null" json:"amount"`
+	Status         string          `gorm:"default:'pending'" json:"status"` // pending, accepted, declined
+	RespondedAt    *time.Time      `json:"responded_at,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+	UpdatedAt      time.Time       `json:"updated_at"`
+
+	// Relationships
+	Proposer     *User  `gorm:"foreignKey:ProposerID" json:"proposer,omitempty"`
+	Counterparty *User  `gorm:"foreignKey:CounterpartyID" json:"counterparty,omitempty"`
+	DebtGroup    *Group `gorm:"foreignKey:DebtGroupID" json:"debt_group,omitempty"`
+	CreditGroup  *Group `gorm:"foreignKey:CreditGroupID" json:"credit_group,omitempty"`
+}
+
+// TableName specifies the table name for DebtOffset model
+func (DebtOffset) TableName() string {
+	return "debt_offsets"
+}
+
+// BeforeCreate hook for DebtOffset
+func (d *DebtOffset) BeforeCreate(tx *gorm.DB) error {
+	d.CreatedAt = time.Now()
+	d.UpdatedAt = time.Now()
+	return nil
+}
+
+// BeforeUpdate hook for DebtOffset
+func (d *DebtOffset) BeforeUpdate(tx *gorm.DB) error {
+	d.UpdatedAt = time.Now()
+	return nil
+}
@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// ExchangeRate represents how much one unit of the base currency is worth in the quote currency
+// from its effective date until the next rate for the same pair
+type ExchangeRate struct {
+	ID            uint            `gorm:"primaryKey" json:"id"`
+	BaseCurrency  string          `gorm:"size:3;not null;uniqueIndex:idx_exchange_rates_pair_date" json:"base_currency"`
+	QuoteCurrency string          `gorm:"size:3;not null;uniqueIndex:idx_exchange_rates_pair_date" json:"quote_currency"`
+	Rate          decimal.Decimal `gorm:"type:decimal(18,8);not null" json:"rate"`
+	EffectiveDate time.Time       `gorm:"type:date;not null;uniqueIndex:idx_exchange_rates_pair_date" json:"effective_date"`
+	CreatedAt     time.Time       `json:"created_at"`
+	UpdatedAt     time.Time       `json:"updated_at"`
+}
+
+// TableName specifies the table name for ExchangeRate model
+func (ExchangeRate) TableName() string {
+	return "exchange_rates"
+}
@@ -13,6 +13,7 @@ type Group struct {
 	Description string         `json:"description,omitempty"`
 	CreatedByID uint           `gorm:"not null" json:"created_by_id"`
 	CreatedBy   *User          `gorm:"foreignKey:CreatedByID" json:"created_by,omitempty"`
+	Currency    string         `gorm:"size:3;not null;default:'USD'" json:"currency"` // ISO 4217 code that balances and settlements are kept in
 	IsActive    bool           `gorm:"default:true" json:"is_active"`
 	CreatedAt   time.Time      `json:"created_at"`
 	UpdatedAt   time.Time      `json:"updated_at"`
@@ -26,12 +27,13 @@ type Group struct {
 
 // GroupMember represents the join table for users and groups with additional fields
 type GroupMember struct {
-	ID        uint      `gorm:"primaryKey" json:"id"`
-	UserID    uint      `gorm:"not null" json:"user_id"`
-	GroupID   uint      `gorm:"not null" json:"group_id"`
-	Role      string    `gorm:"default:'member'" json:"role"` // 'admin' or 'member'
-	JoinedAt  time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"joined_at"`
-	InvitedBy uint      `json:"invited_by,omitempty"`
+	ID        uint       `gorm:"primaryKey" json:"id"`
+	UserID    uint       `gorm:"not null" json:"user_id"`
+	GroupID   uint       `gorm:"not null" json:"group_id"`
+	Role      string     `gorm:"default:'member'" json:"role"` // 'admin' or 'member'
+	JoinedAt  time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"joined_at"`
+	LeftAt    *time.Time `gorm:"index" json:"left_at,omitempty"` // Set once the member has left; the row is kept so past bills still split correctly
+	InvitedBy uint       `json:"invited_by,omitempty"`
 
 	// Relationships
 	User  *User  `gorm:"foreignKey:UserID" json:"user,omitempty"`
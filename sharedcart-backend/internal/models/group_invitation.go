@@ -0,0 +1,46 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// GroupInvitation represents an invitation for someone to join a group, addressed by email so
+// people can be invited before they have an account. It is answered with a signed, expiring token.
+type GroupInvitation struct {
+	ID            uint       `gorm:"primaryKey" json:"id"`
+	GroupID       uint       `gorm:"not null;index" json:"group_id"`
+	Email         string     `gorm:"not null;index" json:"email"`       // Lowercased
+	InviteeID     *uint      `gorm:"index" json:"invitee_id,omitempty"` // Set once the email belongs to an account
+	InvitedByID   uint       `gorm:"not null" json:"invited_by_id"`
+	PlaceholderID *uint      `json:"placeholder_id,omitempty"`        // Placeholder member the invitee takes over on accepting
+	Status        string     `gorm:"default:'pending'" json:"status"` // pending, accepted, declined, revoked
+	ExpiresAt     time.Time  `gorm:"not null" json:"expires_at"`
+	RespondedAt   *time.Time `json:"responded_at,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+
+	// Relationships
+	Group     *Group `gorm:"foreignKey:GroupID" json:"group,omitempty"`
+	Invitee   *User  `gorm:"foreignKey:InviteeID" json:"invitee,omitempty"`
+	InvitedBy *User  `gorm:"foreignKey:InvitedByID" json:"invited_by,omitempty"`
+}
+
+// TableName specifies the table name for GroupInvitation model
+func (GroupInvitation) TableName() string {
+	return "group_invitations"
+}
+
+// BeforeCreate hook for GroupInvitation
+func (i *GroupInvitation) BeforeCreate(tx *gorm.DB) error {
+	i.CreatedAt = time.Now()
+	i.UpdatedAt = time.Now()
+	return nil
+}
+
+// BeforeUpdate hook for GroupInvitation
+func (i *GroupInvitation) BeforeUpdate(tx *gorm.DB) error {
+	i.UpdatedAt = time.Now()
+	return nil
+}
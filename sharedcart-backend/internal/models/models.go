@@ -9,8 +9,17 @@ func GetAllModels() []interface{} {
 		&Bill{},
 		&BillItem{},
 		&ItemOwner{},
+		&BillPayer{},
+		&BillAdjustment{},
 		&Settlement{},
 		&SettlementBill{},
 		&SettlementTransaction{},
+		&SettlementBalance{},
+		&SettlementPayment{},
+		&GroupBalance{},
+		&PaymentPreference{},
+		&ExchangeRate{},
+		&DebtOffset{},
+		&GroupInvitation{},
 	}
 }
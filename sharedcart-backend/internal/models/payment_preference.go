@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+)
+
+// PaymentPreference records whom a member would rather pay, or must never pay, when settling a group
+type PaymentPreference struct {
+	ID           uint      `gorm:"primaryKey" json:"id"`
+	GroupID      uint      `gorm:"not null;uniqueIndex:idx_payment_preferences_group_pair" json:"group_id"`
+	UserID       uint      `gorm:"not null;uniqueIndex:idx_payment_preferences_group_pair" json:"user_id"`        // The payer
+	TargetUserID uint      `gorm:"not null;uniqueIndex:idx_payment_preferences_group_pair" json:"target_user_id"` // The receiver
+	Kind         string    `gorm:"size:20;not null" json:"kind"`                                                  // prefer, block
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+
+	// Relationships
+	TargetUser *User `gorm:"foreignKey:TargetUserID" json:"target_user,omitempty"`
+}
+
+// TableName specifies the table name for PaymentPreference model
+func (PaymentPreference) TableName() string {
+	return "payment_preferences"
+}
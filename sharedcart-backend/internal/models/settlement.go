@@ -14,17 +14,24 @@ type Settlement struct {
 	Group       *Group         `gorm:"foreignKey:GroupID" json:"group,omitempty"`
 	Title       string         `gorm:"not null" json:"title"`
 	Description string         `json:"description,omitempty"`
+	Currency    string         `gorm:"size:3;not null;default:'USD'" json:"currency"` // Group currency at the time of the settlement
 	CreatedByID uint           `gorm:"not null" json:"created_by_id"`
 	CreatedBy   *User          `gorm:"foreignKey:CreatedByID" json:"created_by,omitempty"`
-	Status      string         `gorm:"default:'pending'" json:"status"` // pending, confirmed, completed
+	Status      string         `gorm:"default:'pending'" json:"status"` // pending, confirmed, completed (every transaction paid), cancelled, voided
 	SettledAt   *time.Time     `json:"settled_at,omitempty"`
 	CreatedAt   time.Time      `json:"created_at"`
 	UpdatedAt   time.Time      `json:"updated_at"`
 	DeletedAt   gorm.DeletedAt `gorm:"index" json:"-"`
 
+	// Set when a pending settlement is cancelled or a confirmed one is voided
+	CancelledAt   *time.Time `json:"cancelled_at,omitempty"`
+	CancelledByID *uint      `json:"cancelled_by_id,omitempty"`
+	CancelReason  string     `json:"cancel_reason,omitempty"`
+
 	// Relationships
 	Bills        []Bill                  `gorm:"many2many:settlement_bills;" json:"bills,omitempty"`
 	Transactions []SettlementTransaction `gorm:"foreignKey:SettlementID" json:"transactions,omitempty"`
+	Balances     []SettlementBalance     `gorm:"foreignKey:SettlementID" json:"balances,omitempty"`
 }
 
 // SettlementBill represents the join table for settlements and bills
@@ -40,19 +47,58 @@ type SettlementBill struct {
 
 // SettlementTransaction represents a payment transaction in a settlement
 type SettlementTransaction struct {
+	ID             uint            `gorm:"primaryKey" json:"id"`
+	SettlementID   uint            `gorm:"not null" json:"settlement_id"`
+	Settlement     *Settlement     `gorm:"foreignKey:SettlementID" json:"settlement,omitempty"`
+	FromUserID     uint            `gorm:"not null" json:"from_user_id"`
+	FromUser       *User           `gorm:"foreignKey:FromUserID" json:"from_user,omitempty"`
+	ToUserID       uint            `gorm:"not null" json:"to_user_id"`
+	ToUser         *User           `gorm:"foreignKey:ToUserID" json:"to_user,omitempty"`
+	Amount         decimal.Decimal `gorm:"type:decimal(10,2);not null" json:"amount"`
+	Currency       string          `gorm:"size:3;not null;default:'USD'" json:"currency"` // Currency of Amount, PaidAmount and payments
+	PaidAmount     decimal.Decimal `gorm:"type:decimal(10,2);not null;default:0" json:"paid_amount"`
+	Status         string          `gorm:"default:'pending'" json:"status"` // pending, partially_paid, paid, cancelled
+	PaidAt         *time.Time      `json:"paid_at,omitempty"`
+	AcknowledgedAt *time.Time      `json:"acknowledged_at,omitempty"` // Set when the receiver confirms the money arrived
+	Notes          string          `json:"notes,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+	UpdatedAt      time.Time       `json:"updated_at"`
+
+	// Set when the payer chose to pay in another currency, with the rate snapshotted at that moment
+	PaymentCurrency string           `gorm:"size:3" json:"payment_currency,omitempty"`
+	PaymentAmount   *decimal.Decimal `gorm:"type:decimal(10,2)" json:"payment_amount,omitempty"`
+	ExchangeRate    *decimal.Decimal `gorm:"type:decimal(18,8)" json:"exchange_rate,omitempty"` // Units of PaymentCurrency per unit of Currency
+	RateDate        *time.Time       `gorm:"type:date" json:"rate_date,omitempty"`              // Effective date of the snapshotted rate
+
+	// Computed fields
+	RemainingAmount decimal.Decimal `gorm:"-" json:"remaining_amount"`
+
+	// Relationships
+	Payments []SettlementPayment `gorm:"foreignKey:TransactionID" json:"payments,omitempty"`
+}
+
+// SettlementBalance represents what a member paid and owed across a settlement's bills,
+// stored when the settlement is created so later rate or preference changes don't alter it
+type SettlementBalance struct {
 	ID           uint            `gorm:"primaryKey" json:"id"`
-	SettlementID uint            `gorm:"not null" json:"settlement_id"`
-	Settlement   *Settlement     `gorm:"foreignKey:SettlementID" json:"settlement,omitempty"`
-	FromUserID   uint            `gorm:"not null" json:"from_user_id"`
-	FromUser     *User           `gorm:"foreignKey:FromUserID" json:"from_user,omitempty"`
-	ToUserID     uint            `gorm:"not null" json:"to_user_id"`
-	ToUser       *User           `gorm:"foreignKey:ToUserID" json:"to_user,omitempty"`
-	Amount       decimal.Decimal `gorm:"type:decimal(10,2);not null" json:"amount"`
-	Status       string          `gorm:"default:'pending'" json:"status"` // pending, paid
-	PaidAt       *time.Time      `json:"paid_at,omitempty"`
-	Notes        string          `json:"notes,omitempty"`
-	CreatedAt    time.Time       `json:"created_at"`
-	UpdatedAt    time.Time       `json:"updated_at"`
+	SettlementID uint            `gorm:"not null;index" json:"settlement_id"`
+	UserID       uint            `gorm:"not null" json:"user_id"`
+	Paid         decimal.Decimal `gorm:"type:decimal(12,2);not null" json:"paid"`
+	Owes         decimal.Decimal `gorm:"type:decimal(12,2);not null" json:"owes"`
+	Balance      decimal.Decimal `gorm:"type:decimal(12,2);not null" json:"balance"` // Paid - Owes (positive means user should receive)
+
+	// Relationships
+	User *User `gorm:"foreignKey:UserID" json:"user,omitempty"`
+}
+
+// SettlementPayment represents a full or partial payment towards a settlement transaction
+type SettlementPayment struct {
+	ID             uint            `gorm:"primaryKey" json:"id"`
+	TransactionID  uint            `gorm:"not null;index" json:"transaction_id"`
+	Amount         decimal.Decimal `gorm:"type:decimal(10,2);not null" json:"amount"`
+	Notes          string          `json:"notes,omitempty"`
+	PaidAt         time.Time       `json:"paid_at"`
+	AcknowledgedAt *time.Time      `json:"acknowledged_at,omitempty"`
 }
 
 // TableName specifies the table name for Settlement model
@@ -65,11 +111,21 @@ func (SettlementBill) TableName() string {
 	return "settlement_bills"
 }
 
+// TableName specifies the table name for SettlementBalance model
+func (SettlementBalance) TableName() string {
+	return "settlement_balances"
+}
+
 // TableName specifies the table name for SettlementTransaction model
 func (SettlementTransaction) TableName() string {
 	return "settlement_transactions"
 }
 
+// TableName specifies the table name for SettlementPayment model
+func (SettlementPayment) TableName() string {
+	return "settlement_payments"
+}
+
 // BeforeCreate hooks
 func (s *Settlement) BeforeCreate(tx *gorm.DB) error {
 	s.CreatedAt = time.Now()
@@ -92,3 +148,9 @@ func (st *SettlementTransaction) BeforeUpdate(tx *gorm.DB) error {
 	st.UpdatedAt = time.Now()
 	return nil
 }
+
+// AfterFind computes the amount still to be paid
+func (st *SettlementTransaction) AfterFind(tx *gorm.DB) error {
+	st.RemainingAmount = st.Amount.Sub(st.PaidAmount)
+	return nil
+}
@@ -18,6 +18,10 @@ type User struct {
 	UpdatedAt time.Time      `json:"updated_at"`
 	DeletedAt gorm.DeletedAt `gorm:"index" json:"-"`
 
+	// Placeholders stand in for people without an account. They can't log in, and are merged
+	// into a real user when that person claims them.
+	IsPlaceholder bool `gorm:"default:false" json:"is_placeholder"`
+
 	// Relationships
 	// Note: Use GroupMembers relationship for role-based group access
 	CreatedBills []Bill       `gorm:"foreignKey:PaidByID" json:"created_bills,omitempty"`
@@ -75,10 +75,25 @@ func (s *AuthService) Register(req RegisterRequest) (*AuthResponse, error) {
 		IsActive: true,
 	}
 
-	if err := s.db.Create(&user).Error; err != nil {
+	tx := s.db.Begin()
+	if err := tx.Create(&user).Error; err != nil {
+		tx.Rollback()
 		return nil, fmt.Errorf("failed to create user: %w", err)
 	}
 
+	// Invitations sent before the account existed now show up for it
+	err = tx.Model(&models.GroupInvitation{}).
+		Where("email = ? AND invitee_id IS NULL", req.Email).
+		Update("invitee_id", user.ID).Error
+	if err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("failed to attach invitations: %w", err)
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
 	// Generate JWT token
 	token, err := utils.GenerateJWT(user.ID, user.Email, user.Name, s.config.Secret, s.config.ExpiryHours)
 	if err != nil {
@@ -105,6 +120,11 @@ func (s *AuthService) Login(req LoginRequest) (*AuthResponse, error) {
 		return nil, fmt.Errorf("failed to find user: %w", err)
 	}
 
+	// Placeholders have no password to log in with
+	if user.IsPlaceholder {
+		return nil, fmt.Errorf("invalid credentials")
+	}
+
 	// Check if user is active
 	if !user.IsActive {
 		return nil, fmt.Errorf("account is deactivated")
@@ -9,19 +9,27 @@ import (
 	"github.com/JacksonYuKe/sharedcart-backend/internal/models"
 	"github.com/shopspring/decimal"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+// maxShareRatio is the largest ratio that fits the item_owners.share_ratio column
+var maxShareRatio = decimal.RequireFromString("999.99")
+
 // BillService handles bill-related operations
 type BillService struct {
-	db           *gorm.DB
-	groupService *GroupService
+	db                  *gorm.DB
+	groupService        *GroupService
+	ledgerService       *LedgerService
+	exchangeRateService *ExchangeRateService
 }
 
 // NewBillService creates a new bill service
-func NewBillService(groupService *GroupService) *BillService {
+func NewBillService(groupService *GroupService, ledgerService *LedgerService, exchangeRateService *ExchangeRateService) *BillService {
 	return &BillService{
-		db:           database.DB,
-		groupService: groupService,
+		db:                  database.DB,
+		groupService:        groupService,
+		ledgerService:       ledgerService,
+		exchangeRateService: exchangeRateService,
 	}
 }
 
@@ -31,26 +39,57 @@ type CreateBillRequest struct {
 	Title       string                  `json:"title" binding:"required,min=2,max=100"`
 	Description string                  `json:"description" binding:"max=500"`
 	TotalAmount decimal.Decimal         `json:"total_amount" binding:"required"`
+	Currency    string                  `json:"currency" binding:"omitempty,len=3,uppercase"` // Defaults to the group currency
 	BillDate    time.Time               `json:"bill_date"`
+	PaidByID    uint                    `json:"paid_by_id"` // Optional; defaults to the caller
 	Items       []CreateBillItemRequest `json:"items"`
+	Payers      []BillPayerRequest      `json:"payers"` // Optional; defaults to the caller paying the full total
+	Adjustments []BillAdjustmentRequest `json:"adjustments"`
+}
+
+// BillAdjustmentRequest represents a tax, tip, fee or discount input
+type BillAdjustmentRequest struct {
+	Type         string          `json:"type" binding:"required,oneof=tax tip fee discount"`
+	Name         string          `json:"name" binding:"max=100"`
+	Amount       decimal.Decimal `json:"amount" binding:"required"`
+	SplitEqually bool            `json:"split_equally"`
+}
+
+// BillPayerRequest represents one payer's share of a bill payment
+type BillPayerRequest struct {
+	UserID uint            `json:"user_id" binding:"required"`
+	Amount decimal.Decimal `json:"amount" binding:"required"`
 }
 
 // CreateBillItemRequest represents bill item input
 type CreateBillItemRequest struct {
-	Name        string          `json:"name" binding:"required"`
-	Description string          `json:"description"`
-	Amount      decimal.Decimal `json:"amount" binding:"required"`
-	Quantity    int             `json:"quantity"`
-	IsShared    bool            `json:"is_shared"`
-	OwnerIDs    []uint          `json:"owner_ids"` // Required if IsShared is false
+	Name        string                  `json:"name" binding:"required"`
+	Description string                  `json:"description"`
+	Amount      decimal.Decimal         `json:"amount" binding:"required"`
+	Quantity    decimal.Decimal         `json:"quantity"` // Fractional for weighed goods, defaults to 1
+	Unit        string                  `json:"unit" binding:"omitempty,oneof=kg lb L each"`
+	IsShared    bool                    `json:"is_shared"`
+	SplitMode   string                  `json:"split_mode" binding:"omitempty,oneof=equal shares percent exact"`
+	OwnerIDs    []uint                  `json:"owner_ids"`    // Required if IsShared is false; for shared items, limits the split to these members
+	OwnerShares []ItemOwnerShareRequest `json:"owner_shares"` // Per-owner values for shares, percent and exact modes, takes precedence over OwnerIDs
+}
+
+// ItemOwnerShareRequest represents an item owner with a custom split value
+type ItemOwnerShareRequest struct {
+	UserID     uint            `json:"user_id" binding:"required"`
+	ShareRatio decimal.Decimal `json:"share_ratio"` // Shares mode, defaults to 1 when omitted
+	Percent    decimal.Decimal `json:"percent"`     // Percent mode, must sum to 100
+	Amount     decimal.Decimal `json:"amount"`      // Exact mode, must sum to the item total
 }
 
 // UpdateBillRequest represents bill update input
 type UpdateBillRequest struct {
-	Title       string          `json:"title" binding:"required,min=2,max=100"`
-	Description string          `json:"description" binding:"max=500"`
-	TotalAmount decimal.Decimal `json:"total_amount" binding:"required"`
-	BillDate    time.Time       `json:"bill_date"`
+	Title       string             `json:"title" binding:"required,min=2,max=100"`
+	Description string             `json:"description" binding:"max=500"`
+	TotalAmount decimal.Decimal    `json:"total_amount" binding:"required"`
+	Currency    string             `json:"currency" binding:"omitempty,len=3,uppercase"` // Optional; keeps the current currency when empty
+	BillDate    time.Time          `json:"bill_date"`
+	Payers      []BillPayerRequest `json:"payers"` // Optional; replaces the existing payers when set
 }
 
 // CreateBill creates a new bill with items
@@ -65,6 +104,25 @@ func (s *BillService) CreateBill(userID uint, req CreateBillRequest) (*models.Bi
 		req.BillDate = time.Now()
 	}
 
+	// The caller may record a bill on behalf of another member
+	paidByID := userID
+	if req.PaidByID != 0 {
+		if !s.groupService.IsUserMember(req.GroupID, req.PaidByID) {
+			return nil, errors.New("payer is not a member of this group")
+		}
+		paidByID = req.PaidByID
+	}
+
+	// Bills are in the group currency unless entered in another one
+	currency := req.Currency
+	if currency == "" {
+		var group models.Group
+		if err := s.db.Select("currency").First(&group, req.GroupID).Error; err != nil {
+			return nil, errors.New("group not found")
+		}
+		currency = group.Currency
+	}
+
 	// Start transaction
 	tx := s.db.Begin()
 	defer func() {
@@ -79,7 +137,9 @@ func (s *BillService) CreateBill(userID uint, req CreateBillRequest) (*models.Bi
 		Title:       req.Title,
 		Description: req.Description,
 		TotalAmount: req.TotalAmount,
-		PaidByID:    userID,
+		Currency:    currency,
+		PaidByID:    paidByID,
+		CreatedByID: userID,
 		BillDate:    req.BillDate,
 		Status:      "pending",
 	}
@@ -92,9 +152,10 @@ func (s *BillService) CreateBill(userID uint, req CreateBillRequest) (*models.Bi
 	// Create bill items
 	var itemsTotal decimal.Decimal
 	for _, itemReq := range req.Items {
-		// Set default quantity
-		if itemReq.Quantity == 0 {
-			itemReq.Quantity = 1
+		// Set default quantity and validate the split
+		if err := itemReq.normalize(); err != nil {
+			tx.Rollback()
+			return nil, err
 		}
 
 		// Debug logging
@@ -106,47 +167,64 @@ func (s *BillService) CreateBill(userID uint, req CreateBillRequest) (*models.Bi
 			Description: itemReq.Description,
 			Amount:      itemReq.Amount,
 			Quantity:    itemReq.Quantity,
+			Unit:        itemReq.Unit,
 			IsShared:    itemReq.IsShared,
+			SplitMode:   itemReq.SplitMode,
 		}
 
 		fmt.Printf("DEBUG: Item struct created - IsShared: %t\n", item.IsShared)
 
 		// Create the item first using Select to ensure all fields are saved
-		if err := tx.Select("bill_id", "name", "description", "amount", "quantity", "is_shared").Create(&item).Error; err != nil {
+		if err := tx.Select("bill_id", "name", "description", "amount", "quantity", "unit", "is_shared", "split_mode").Create(&item).Error; err != nil {
 			tx.Rollback()
 			return nil, fmt.Errorf("failed to create item %s: %w", itemReq.Name, err)
 		}
 
-		// Add item owners for personal items
-		if !itemReq.IsShared && len(itemReq.OwnerIDs) > 0 {
-			for _, ownerID := range itemReq.OwnerIDs {
-				// Verify owner is a group member
-				if !s.groupService.IsUserMember(req.GroupID, ownerID) {
-					tx.Rollback()
-					return nil, fmt.Errorf("user %d is not a member of the group", ownerID)
-				}
-
-				owner := models.ItemOwner{
-					ItemID:     item.ID,
-					UserID:     ownerID,
-					ShareRatio: decimal.NewFromInt(1), // Equal share by default
-				}
-
-				if err := tx.Create(&owner).Error; err != nil {
-					tx.Rollback()
-					return nil, fmt.Errorf("failed to add item owner: %w", err)
-				}
-			}
+		// Add item owners, or participants for shared items
+		if err := s.addItemOwners(tx, req.GroupID, item.ID, itemReq); err != nil {
+			tx.Rollback()
+			return nil, err
 		}
 
 		// Calculate total
-		itemsTotal = itemsTotal.Add(itemReq.Amount.Mul(decimal.NewFromInt(int64(itemReq.Quantity))))
+		itemsTotal = itemsTotal.Add(lineTotal(itemReq.Amount, itemReq.Quantity))
+	}
+
+	// Record who paid, when the payment was split
+	if err := s.addBillPayers(tx, &bill, req.Payers); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	// Create tax, tip, fee and discount adjustments
+	var adjustmentsTotal decimal.Decimal
+	for _, adjReq := range req.Adjustments {
+		if !adjReq.Amount.IsPositive() {
+			tx.Rollback()
+			return nil, fmt.Errorf("%s amount must be positive", adjReq.Type)
+		}
+
+		adjustment := models.BillAdjustment{
+			BillID:       bill.ID,
+			Type:         adjReq.Type,
+			Name:         adjReq.Name,
+			Amount:       adjReq.Amount,
+			SplitEqually: adjReq.SplitEqually,
+		}
+
+		if err := tx.Create(&adjustment).Error; err != nil {
+			tx.Rollback()
+			return nil, fmt.Errorf("failed to create %s adjustment: %w", adjReq.Type, err)
+		}
+
+		adjustmentsTotal = adjustmentsTotal.Add(adjustment.SignedAmount())
 	}
 
-	// Validate total amount matches items total (with small tolerance for rounding)
-	if !req.TotalAmount.Sub(itemsTotal).Abs().LessThan(decimal.NewFromFloat(0.01)) {
+	// Validate total amount matches items plus adjustments (with small tolerance for rounding)
+	expectedTotal := itemsTotal.Add(adjustmentsTotal)
+	if !req.TotalAmount.Sub(expectedTotal).Abs().LessThan(decimal.NewFromFloat(0.01)) {
 		tx.Rollback()
-		return nil, fmt.Errorf("total amount (%s) doesn't match sum of items (%s)", req.TotalAmount, itemsTotal)
+		return nil, fmt.Errorf("total amount (%s) doesn't match sum of items (%s) plus adjustments (%s)", req.TotalAmount, itemsTotal, adjustmentsTotal)
 	}
 
 	// Commit transaction
@@ -155,7 +233,7 @@ func (s *BillService) CreateBill(userID uint, req CreateBillRequest) (*models.Bi
 	}
 
 	// Load full bill data
-	if err := s.db.Preload("Group").Preload("PaidBy").Preload("Items").Preload("Items.Owners").First(&bill, bill.ID).Error; err != nil {
+	if err := s.db.Preload("Group").Preload("PaidBy").Preload("CreatedBy").Preload("Payers.User").Preload("Adjustments").Preload("Items").Preload("Items.Owners").Preload("Items.ItemOwners").First(&bill, bill.ID).Error; err != nil {
 		return nil, fmt.Errorf("failed to load bill data: %w", err)
 	}
 
@@ -196,8 +274,12 @@ func (s *BillService) GetBillByID(billID, userID uint) (*models.Bill, error) {
 	err := s.db.
 		Preload("Group").
 		Preload("PaidBy").
+		Preload("CreatedBy").
+		Preload("Payers.User").
+		Preload("Adjustments").
 		Preload("Items").
 		Preload("Items.Owners").
+		Preload("Items.ItemOwners").
 		First(&bill, billID).Error
 
 	if err != nil {
@@ -229,21 +311,44 @@ func (s *BillService) UpdateBill(billID, userID uint, req UpdateBillRequest) (*m
 	}
 
 	// Only bill creator or group admin can update
-	if bill.PaidByID != userID && !s.groupService.IsUserAdmin(bill.GroupID, userID) {
+	if !s.canManageBill(bill, userID) {
 		return nil, errors.New("only bill creator or group admin can update the bill")
 	}
 
+	// Start transaction
+	tx := s.db.Begin()
+
 	// Update fields
 	bill.Title = req.Title
 	bill.Description = req.Description
 	bill.TotalAmount = req.TotalAmount
 	bill.BillDate = req.BillDate
+	if req.Currency != "" {
+		bill.Currency = req.Currency
+	}
 
-	if err := s.db.Save(&bill).Error; err != nil {
+	if err := tx.Omit("Payers").Save(&bill).Error; err != nil {
+		tx.Rollback()
 		return nil, fmt.Errorf("failed to update bill: %w", err)
 	}
 
-	return bill, nil
+	// Replace payers if a new split was given
+	if req.Payers != nil {
+		if err := tx.Where("bill_id = ?", bill.ID).Delete(&models.BillPayer{}).Error; err != nil {
+			tx.Rollback()
+			return nil, fmt.Errorf("failed to update bill payers: %w", err)
+		}
+		if err := s.addBillPayers(tx, bill, req.Payers); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return s.GetBillByID(billID, userID)
 }
 
 // DeleteBill soft deletes a bill
@@ -260,7 +365,7 @@ func (s *BillService) DeleteBill(billID, userID uint) error {
 	}
 
 	// Only bill creator or group admin can delete
-	if bill.PaidByID != userID && !s.groupService.IsUserAdmin(bill.GroupID, userID) {
+	if !s.canManageBill(bill, userID) {
 		return errors.New("only bill creator or group admin can delete the bill")
 	}
 
@@ -286,25 +391,27 @@ func (s *BillService) AddBillItem(billID, userID uint, req CreateBillItemRequest
 	}
 
 	// Only bill creator or group admin can add items
-	if bill.PaidByID != userID && !s.groupService.IsUserAdmin(bill.GroupID, userID) {
+	if !s.canManageBill(bill, userID) {
 		return nil, errors.New("only bill creator or group admin can add items")
 	}
 
+	// Set default quantity and validate the split
+	if err := req.normalize(); err != nil {
+		return nil, err
+	}
+
 	// Start transaction
 	tx := s.db.Begin()
 
-	// Create item
-	if req.Quantity == 0 {
-		req.Quantity = 1
-	}
-
 	item := models.BillItem{
 		BillID:      billID,
 		Name:        req.Name,
 		Description: req.Description,
 		Amount:      req.Amount,
 		Quantity:    req.Quantity,
+		Unit:        req.Unit,
 		IsShared:    req.IsShared,
+		SplitMode:   req.SplitMode,
 	}
 
 	if err := tx.Create(&item).Error; err != nil {
@@ -312,30 +419,14 @@ func (s *BillService) AddBillItem(billID, userID uint, req CreateBillItemRequest
 		return nil, fmt.Errorf("failed to create item: %w", err)
 	}
 
-	// Add owners for personal items
-	if !req.IsShared && len(req.OwnerIDs) > 0 {
-		for _, ownerID := range req.OwnerIDs {
-			if !s.groupService.IsUserMember(bill.GroupID, ownerID) {
-				tx.Rollback()
-				return nil, fmt.Errorf("user %d is not a member of the group", ownerID)
-			}
-
-			owner := models.ItemOwner{
-				ItemID:     item.ID,
-				UserID:     ownerID,
-				ShareRatio: decimal.NewFromInt(1),
-			}
-
-			if err := tx.Create(&owner).Error; err != nil {
-				tx.Rollback()
-				return nil, fmt.Errorf("failed to add item owner: %w", err)
-			}
-		}
+	// Add owners, or participants for shared items
+	if err := s.addItemOwners(tx, bill.GroupID, item.ID, req); err != nil {
+		tx.Rollback()
+		return nil, err
 	}
 
 	// Update bill total
-	itemTotal := req.Amount.Mul(decimal.NewFromInt(int64(req.Quantity)))
-	bill.TotalAmount = bill.TotalAmount.Add(itemTotal)
+	bill.TotalAmount = bill.TotalAmount.Add(lineTotal(req.Amount, req.Quantity))
 
 	if err := tx.Save(&bill).Error; err != nil {
 		tx.Rollback()
@@ -364,7 +455,7 @@ func (s *BillService) UpdateBillItem(billID, itemID, userID uint, req CreateBill
 	}
 
 	// Only bill creator or group admin can update items
-	if bill.PaidByID != userID && !s.groupService.IsUserAdmin(bill.GroupID, userID) {
+	if !s.canManageBill(bill, userID) {
 		return nil, errors.New("only bill creator or group admin can update items")
 	}
 
@@ -374,9 +465,14 @@ func (s *BillService) UpdateBillItem(billID, itemID, userID uint, req CreateBill
 		return nil, errors.New("item not found")
 	}
 
+	// Set default quantity and validate the split
+	if err := req.normalize(); err != nil {
+		return nil, err
+	}
+
 	// Calculate the difference in total
-	oldTotal := item.Amount.Mul(decimal.NewFromInt(int64(item.Quantity)))
-	newTotal := req.Amount.Mul(decimal.NewFromInt(int64(req.Quantity)))
+	oldTotal := lineTotal(item.Amount, item.Quantity)
+	newTotal := lineTotal(req.Amount, req.Quantity)
 	difference := newTotal.Sub(oldTotal)
 
 	// Start transaction
@@ -387,7 +483,9 @@ func (s *BillService) UpdateBillItem(billID, itemID, userID uint, req CreateBill
 	item.Description = req.Description
 	item.Amount = req.Amount
 	item.Quantity = req.Quantity
+	item.Unit = req.Unit
 	item.IsShared = req.IsShared
+	item.SplitMode = req.SplitMode
 
 	if err := tx.Save(&item).Error; err != nil {
 		tx.Rollback()
@@ -397,24 +495,9 @@ func (s *BillService) UpdateBillItem(billID, itemID, userID uint, req CreateBill
 	// Update owners
 	tx.Where("item_id = ?", item.ID).Delete(&models.ItemOwner{})
 
-	if !req.IsShared && len(req.OwnerIDs) > 0 {
-		for _, ownerID := range req.OwnerIDs {
-			if !s.groupService.IsUserMember(bill.GroupID, ownerID) {
-				tx.Rollback()
-				return nil, fmt.Errorf("user %d is not a member of the group", ownerID)
-			}
-
-			owner := models.ItemOwner{
-				ItemID:     item.ID,
-				UserID:     ownerID,
-				ShareRatio: decimal.NewFromInt(1),
-			}
-
-			if err := tx.Create(&owner).Error; err != nil {
-				tx.Rollback()
-				return nil, fmt.Errorf("failed to add item owner: %w", err)
-			}
-		}
+	if err := s.addItemOwners(tx, bill.GroupID, item.ID, req); err != nil {
+		tx.Rollback()
+		return nil, err
 	}
 
 	// Update bill total
@@ -446,7 +529,7 @@ func (s *BillService) DeleteBillItem(billID, itemID, userID uint) error {
 	}
 
 	// Only bill creator or group admin can delete items
-	if bill.PaidByID != userID && !s.groupService.IsUserAdmin(bill.GroupID, userID) {
+	if !s.canManageBill(bill, userID) {
 		return errors.New("only bill creator or group admin can delete items")
 	}
 
@@ -469,8 +552,7 @@ func (s *BillService) DeleteBillItem(billID, itemID, userID uint) error {
 	}
 
 	// Update bill total
-	itemTotal := item.Amount.Mul(decimal.NewFromInt(int64(item.Quantity)))
-	bill.TotalAmount = bill.TotalAmount.Sub(itemTotal)
+	bill.TotalAmount = bill.TotalAmount.Sub(lineTotal(item.Amount, item.Quantity))
 
 	if err := tx.Save(&bill).Error; err != nil {
 		tx.Rollback()
@@ -491,19 +573,363 @@ func (s *BillService) FinalizeBill(billID, userID uint) error {
 	}
 
 	// Only bill creator or group admin can finalize
-	if bill.PaidByID != userID && !s.groupService.IsUserAdmin(bill.GroupID, userID) {
+	if !s.canManageBill(bill, userID) {
 		return errors.New("only bill creator or group admin can finalize the bill")
 	}
 
+	// Finalizing twice would count the bill twice in the group balances
+	if bill.Status != "pending" {
+		return errors.New("bill is not pending")
+	}
+
 	// Check if bill has items
 	if len(bill.Items) == 0 {
 		return errors.New("cannot finalize bill without items")
 	}
 
-	// Update status
-	bill.Status = "finalized"
-	if err := s.db.Save(&bill).Error; err != nil {
-		return fmt.Errorf("failed to finalize bill: %w", err)
+	// Item changes may have moved the total away from the payer split
+	if len(bill.Payers) > 0 {
+		paidTotal := decimal.Zero
+		for _, payer := range bill.Payers {
+			paidTotal = paidTotal.Add(payer.Amount)
+		}
+		if !paidTotal.Equal(bill.TotalAmount) {
+			return fmt.Errorf("payer amounts (%s) don't match bill total (%s)", paidTotal, bill.TotalAmount)
+		}
+	}
+
+	// Former members still take part in bills from when they belonged to the group
+	members, err := s.groupService.memberHistory(bill.GroupID)
+	if err != nil {
+		return err
+	}
+
+	// Balances are kept in the group currency, converted at the rate on the bill date
+	groupCurrency := bill.Currency
+	if bill.Group != nil {
+		groupCurrency = bill.Group.Currency
+	}
+	converted, _, err := s.exchangeRateService.convertBill(bill, groupCurrency)
+	if err != nil {
+		return err
+	}
+
+	// Start transaction
+	tx := s.db.Begin()
+
+	// Update status only if nobody finalized the bill in the meantime, so it is recorded once
+	result := tx.Model(&models.Bill{}).
+		Where("id = ? AND status = ?", bill.ID, "pending").
+		Update("status", "finalized")
+	if result.Error != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to finalize bill: %w", result.Error)
+	}
+	if result.RowsAffected != 1 {
+		tx.Rollback()
+		return errors.New("bill is not pending")
+	}
+
+	// Add the bill to the group's running balances
+	if err := s.ledgerService.recordBill(tx, converted, members); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// BackfillLedger builds the running balances of groups whose bills were finalized before balances
+// were kept, from those bills and the settlement payments made since. It returns how many groups
+// it filled in.
+func (s *BillService) BackfillLedger() (int, error) {
+	var groupIDs []uint
+	err := s.db.Model(&models.Bill{}).
+		Distinct().
+		Where("status IN ?", []string{"finalized", "settled"}).
+		Where("NOT EXISTS (SELECT 1 FROM group_balances WHERE group_balances.group_id = bills.group_id)").
+		Pluck("group_id", &groupIDs).Error
+
+	if err != nil {
+		return 0, fmt.Errorf("failed to find groups without balances: %w", err)
+	}
+
+	count := 0
+	for _, groupID := range groupIDs {
+		filled, err := s.backfillGroupLedger(groupID)
+		if err != nil {
+			return count, err
+		}
+		if filled {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// backfillGroupLedger builds one group's running balances, unless it already has some
+func (s *BillService) backfillGroupLedger(groupID uint) (bool, error) {
+	// Start transaction
+	tx := s.db.Begin()
+
+	var group models.Group
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&group, groupID).Error
+	if err != nil {
+		tx.Rollback()
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// Deleted groups have no balances to show
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to get group %d: %w", groupID, err)
+	}
+
+	// Another instance may have filled the group in while this one waited for the lock
+	var existing int64
+	tx.Model(&models.GroupBalance{}).Where("group_id = ?", groupID).Count(&existing)
+	if existing > 0 {
+		tx.Rollback()
+		return false, nil
+	}
+
+	var bills []models.Bill
+	err = tx.
+		Where("group_id = ? AND status IN ?", groupID, []string{"finalized", "settled"}).
+		Preload("Payers").
+		Preload("Adjustments").
+		Preload("Items.ItemOwners").
+		Order("id").
+		Find(&bills).Error
+	if err != nil {
+		tx.Rollback()
+		return false, fmt.Errorf("failed to fetch bills: %w", err)
+	}
+
+	members, err := s.groupService.memberHistory(groupID)
+	if err != nil {
+		tx.Rollback()
+		return false, err
+	}
+
+	// Balances are kept in the group currency, as FinalizeBill records them
+	converted := make([]*models.Bill, 0, len(bills))
+	for i := range bills {
+		bill, _, err := s.exchangeRateService.convertBill(&bills[i], group.Currency)
+		if err != nil {
+			tx.Rollback()
+			return false, fmt.Errorf("failed to convert bill %d: %w", bills[i].ID, err)
+		}
+		converted = append(converted, bill)
+	}
+
+	var transactions []models.SettlementTransaction
+	err = tx.
+		Joins("JOIN settlements ON settlements.id = settlement_transactions.settlement_id AND settlements.deleted_at IS NULL").
+		Where("settlements.group_id = ?", groupID).
+		Find(&transactions).Error
+	if err != nil {
+		tx.Rollback()
+		return false, fmt.Errorf("failed to fetch settlement transactions: %w", err)
+	}
+
+	if err := s.ledgerService.restore(tx, groupID, ledgerFromHistory(converted, members, transactions)); err != nil {
+		tx.Rollback()
+		return false, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return false, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return true, nil
+}
+
+// canManageBill checks if a user may change a bill: the member who entered it or a group admin
+func (s *BillService) canManageBill(bill *models.Bill, userID uint) bool {
+	createdByID := bill.CreatedByID
+	if createdByID == 0 {
+		// Bills entered before creators were recorded belong to their payer
+		createdByID = bill.PaidByID
+	}
+	return createdByID == userID || s.groupService.IsUserAdmin(bill.GroupID, userID)
+}
+
+// addBillPayers validates and stores the payers of a bill split across several people
+func (s *BillService) addBillPayers(tx *gorm.DB, bill *models.Bill, payers []BillPayerRequest) error {
+	if len(payers) == 0 {
+		return nil
+	}
+
+	paidTotal := decimal.Zero
+	seen := make(map[uint]bool)
+	for _, payerReq := range payers {
+		if !s.groupService.IsUserMember(bill.GroupID, payerReq.UserID) {
+			return fmt.Errorf("user %d is not a member of the group", payerReq.UserID)
+		}
+		if seen[payerReq.UserID] {
+			return fmt.Errorf("user %d is listed as payer more than once", payerReq.UserID)
+		}
+		seen[payerReq.UserID] = true
+
+		if !payerReq.Amount.IsPositive() {
+			return fmt.Errorf("amount paid by user %d must be positive", payerReq.UserID)
+		}
+		paidTotal = paidTotal.Add(payerReq.Amount)
+
+		payer := models.BillPayer{
+			BillID: bill.ID,
+			UserID: payerReq.UserID,
+			Amount: payerReq.Amount,
+		}
+		if err := tx.Create(&payer).Error; err != nil {
+			return fmt.Errorf("failed to add bill payer: %w", err)
+		}
+	}
+
+	if !paidTotal.Equal(bill.TotalAmount) {
+		return fmt.Errorf("payer amounts (%s) don't match bill total (%s)", paidTotal, bill.TotalAmount)
+	}
+
+	return nil
+}
+
+// ownerShares returns the item owners with their split values, defaulting to equal shares
+func (req CreateBillItemRequest) ownerShares() []ItemOwnerShareRequest {
+	if len(req.OwnerShares) > 0 {
+		return req.OwnerShares
+	}
+
+	shares := make([]ItemOwnerShareRequest, 0, len(req.OwnerIDs))
+	for _, ownerID := range req.OwnerIDs {
+		shares = append(shares, ItemOwnerShareRequest{UserID: ownerID})
+	}
+	return shares
+}
+
+// lineTotal returns the line total for an item, rounded to the minor unit like a receipt
+func lineTotal(amount, quantity decimal.Decimal) decimal.Decimal {
+	return amount.Mul(quantity).Round(minorUnitPlaces)
+}
+
+// normalize defaults the quantity, unit and split mode, and validates the per-owner values
+func (req *CreateBillItemRequest) normalize() error {
+	if req.Quantity.IsZero() {
+		req.Quantity = decimal.NewFromInt(1)
+	}
+	if req.Quantity.IsNegative() {
+		return fmt.Errorf("quantity for item %s must be positive", req.Name)
+	}
+	if req.Unit == "" {
+		req.Unit = "each"
+	}
+
+	if req.SplitMode == "" {
+		req.SplitMode = "equal"
+		if len(req.OwnerShares) > 0 {
+			req.SplitMode = "shares"
+		}
+	}
+
+	owners := req.ownerShares()
+	if req.SplitMode != "equal" && len(owners) == 0 {
+		return fmt.Errorf("item %s needs owners for %s split", req.Name, req.SplitMode)
+	}
+	if req.IsShared && req.SplitMode != "equal" {
+		return fmt.Errorf("shared item %s can only be split equally", req.Name)
+	}
+
+	// A second row for the same owner would count their share twice
+	seen := make(map[uint]bool, len(owners))
+	for _, owner := range owners {
+		if seen[owner.UserID] {
+			return fmt.Errorf("user %d is listed as owner of item %s more than once", owner.UserID, req.Name)
+		}
+		seen[owner.UserID] = true
+	}
+
+	switch req.SplitMode {
+	case "shares":
+		for _, owner := range owners {
+			if owner.ShareRatio.IsNegative() || owner.ShareRatio.GreaterThan(maxShareRatio) {
+				return fmt.Errorf("share ratio for user %d must be between 0 and %s", owner.UserID, maxShareRatio)
+			}
+			if !fitsShareColumn(owner.ShareRatio) {
+				return fmt.Errorf("share ratio for user %d can have at most %d decimal places", owner.UserID, minorUnitPlaces)
+			}
+		}
+	case "percent":
+		totalPercent := decimal.Zero
+		for _, owner := range owners {
+			if !owner.Percent.IsPositive() {
+				return fmt.Errorf("percent for user %d must be positive", owner.UserID)
+			}
+			if !fitsShareColumn(owner.Percent) {
+				return fmt.Errorf("percent for user %d can have at most %d decimal places", owner.UserID, minorUnitPlaces)
+			}
+			totalPercent = totalPercent.Add(owner.Percent)
+		}
+		if !totalPercent.Equal(decimal.NewFromInt(100)) {
+			return fmt.Errorf("percents for item %s sum to %s, must sum to 100", req.Name, totalPercent)
+		}
+	case "exact":
+		total := lineTotal(req.Amount, req.Quantity)
+		totalAmount := decimal.Zero
+		for _, owner := range owners {
+			if owner.Amount.IsNegative() {
+				return fmt.Errorf("amount for user %d cannot be negative", owner.UserID)
+			}
+			if !fitsShareColumn(owner.Amount) {
+				return fmt.Errorf("amount for user %d can have at most %d decimal places", owner.UserID, minorUnitPlaces)
+			}
+			totalAmount = totalAmount.Add(owner.Amount)
+		}
+		if !totalAmount.Equal(total) {
+			return fmt.Errorf("exact amounts for item %s sum to %s, must equal item total %s", req.Name, totalAmount, total)
+		}
+	}
+
+	return nil
+}
+
+// fitsShareColumn checks that a share ratio, percent or exact amount is stored without rounding,
+// since the item_owners columns keep two decimal places and validation must see what is saved
+func fitsShareColumn(value decimal.Decimal) bool {
+	return value.Equal(value.Round(minorUnitPlaces))
+}
+
+// addItemOwners validates and stores the owners of a personal item, or the
+// participants of a shared item that is split among a subset of members
+func (s *BillService) addItemOwners(tx *gorm.DB, groupID, itemID uint, req CreateBillItemRequest) error {
+	for _, share := range req.ownerShares() {
+		// Verify owner is a group member
+		if !s.groupService.IsUserMember(groupID, share.UserID) {
+			return fmt.Errorf("user %d is not a member of the group", share.UserID)
+		}
+
+		owner := models.ItemOwner{
+			ItemID:     itemID,
+			UserID:     share.UserID,
+			ShareRatio: decimal.NewFromInt(1), // Equal share by default
+		}
+
+		switch req.SplitMode {
+		case "shares":
+			if share.ShareRatio.IsPositive() {
+				owner.ShareRatio = share.ShareRatio
+			}
+		case "percent":
+			owner.ShareRatio = share.Percent
+		case "exact":
+			owner.Amount = share.Amount
+		}
+
+		if err := tx.Create(&owner).Error; err != nil {
+			return fmt.Errorf("failed to add item owner: %w", err)
+		}
 	}
 
 	return nil
@@ -0,0 +1,238 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestNormalizeItemRequest(t *testing.T) {
+	tests := []struct {
+		name     string
+		req      CreateBillItemRequest
+		wantMode string
+		wantErr  bool
+	}{
+		{
+			name:     "Defaults to equal",
+			req:      CreateBillItemRequest{Name: "Milk", Amount: decimal.NewFromInt(4), Quantity: decimal.NewFromInt(1), OwnerIDs: []uint{1, 2}},
+			wantMode: "equal",
+		},
+		{
+			name: "Owner shares default to shares",
+			req: CreateBillItemRequest{Name: "Pizza", Amount: decimal.NewFromInt(18), Quantity: decimal.NewFromInt(1), OwnerShares: []ItemOwnerShareRequest{
+				{UserID: 1, ShareRatio: decimal.NewFromInt(2)},
+				{UserID: 2, ShareRatio: decimal.NewFromInt(1)},
+			}},
+			wantMode: "shares",
+		},
+		{
+			name: "Percents must sum to 100",
+			req: CreateBillItemRequest{Name: "Wine", Amount: decimal.NewFromInt(30), Quantity: decimal.NewFromInt(1), SplitMode: "percent", OwnerShares: []ItemOwnerShareRequest{
+				{UserID: 1, Percent: decimal.NewFromInt(50)},
+				{UserID: 2, Percent: decimal.NewFromInt(40)},
+			}},
+			wantErr: true,
+		},
+		{
+			name: "Valid percents",
+			req: CreateBillItemRequest{Name: "Wine", Amount: decimal.NewFromInt(30), Quantity: decimal.NewFromInt(1), SplitMode: "percent", OwnerShares: []ItemOwnerShareRequest{
+				{UserID: 1, Percent: decimal.NewFromInt(60)},
+				{UserID: 2, Percent: decimal.NewFromInt(40)},
+			}},
+			wantMode: "percent",
+		},
+		{
+			name: "Percents are stored with two decimal places",
+			req: CreateBillItemRequest{Name: "Wine", Amount: decimal.NewFromInt(30), Quantity: decimal.NewFromInt(1), SplitMode: "percent", OwnerShares: []ItemOwnerShareRequest{
+				{UserID: 1, Percent: decimal.RequireFromString("33.333")},
+				{UserID: 2, Percent: decimal.RequireFromString("33.333")},
+				{UserID: 3, Percent: decimal.RequireFromString("33.334")},
+			}},
+			wantErr: true,
+		},
+		{
+			name: "Percents to two decimal places",
+			req: CreateBillItemRequest{Name: "Wine", Amount: decimal.NewFromInt(30), Quantity: decimal.NewFromInt(1), SplitMode: "percent", OwnerShares: []ItemOwnerShareRequest{
+				{UserID: 1, Percent: decimal.RequireFromString("33.33")},
+				{UserID: 2, Percent: decimal.RequireFromString("33.33")},
+				{UserID: 3, Percent: decimal.RequireFromString("33.34")},
+			}},
+			wantMode: "percent",
+		},
+		{
+			name: "Share ratios are stored with two decimal places",
+			req: CreateBillItemRequest{Name: "Pizza", Amount: decimal.NewFromInt(18), Quantity: decimal.NewFromInt(1), OwnerShares: []ItemOwnerShareRequest{
+				{UserID: 1, ShareRatio: decimal.RequireFromString("1.005")},
+				{UserID: 2, ShareRatio: decimal.NewFromInt(1)},
+			}},
+			wantErr: true,
+		},
+		{
+			name: "Owner listed twice",
+			req: CreateBillItemRequest{Name: "Pizza", Amount: decimal.NewFromInt(18), Quantity: decimal.NewFromInt(1), OwnerShares: []ItemOwnerShareRequest{
+				{UserID: 1, ShareRatio: decimal.NewFromInt(1)},
+				{UserID: 2, ShareRatio: decimal.NewFromInt(1)},
+				{UserID: 1, ShareRatio: decimal.NewFromInt(1)},
+			}},
+			wantErr: true,
+		},
+		{
+			name:    "Owner ID listed twice",
+			req:     CreateBillItemRequest{Name: "Milk", Amount: decimal.NewFromInt(4), Quantity: decimal.NewFromInt(1), OwnerIDs: []uint{1, 2, 2}},
+			wantErr: true,
+		},
+		{
+			name: "Exact amounts must match item total",
+			req: CreateBillItemRequest{Name: "Cheese", Amount: decimal.NewFromInt(5), Quantity: decimal.NewFromInt(2), SplitMode: "exact", OwnerShares: []ItemOwnerShareRequest{
+				{UserID: 1, Amount: decimal.NewFromInt(5)},
+				{UserID: 2, Amount: decimal.NewFromInt(4)},
+			}},
+			wantErr: true,
+		},
+		{
+			name: "Valid exact amounts",
+			req: CreateBillItemRequest{Name: "Cheese", Amount: decimal.NewFromInt(5), Quantity: decimal.NewFromInt(2), SplitMode: "exact", OwnerShares: []ItemOwnerShareRequest{
+				{UserID: 1, Amount: decimal.NewFromInt(6)},
+				{UserID: 2, Amount: decimal.NewFromInt(4)},
+			}},
+			wantMode: "exact",
+		},
+		{
+			name: "Exact amounts are stored with two decimal places",
+			req: CreateBillItemRequest{Name: "Cheese", Amount: decimal.NewFromInt(10), Quantity: decimal.NewFromInt(1), SplitMode: "exact", OwnerShares: []ItemOwnerShareRequest{
+				{UserID: 1, Amount: decimal.RequireFromString("3.335")},
+				{UserID: 2, Amount: decimal.RequireFromString("6.665")},
+			}},
+			wantErr: true,
+		},
+		{
+			name:    "Shared items split equally only",
+			req:     CreateBillItemRequest{Name: "Bread", Amount: decimal.NewFromInt(3), Quantity: decimal.NewFromInt(1), IsShared: true, SplitMode: "shares", OwnerIDs: []uint{1}},
+			wantErr: true,
+		},
+		{
+			name:     "Missing quantity defaults to one",
+			req:      CreateBillItemRequest{Name: "Bananas", Amount: decimal.NewFromInt(2), OwnerIDs: []uint{1}},
+			wantMode: "equal",
+		},
+		{
+			name:    "Negative quantity",
+			req:     CreateBillItemRequest{Name: "Bananas", Amount: decimal.NewFromInt(2), Quantity: decimal.NewFromInt(-1)},
+			wantErr: true,
+		},
+		{
+			name:    "Non-equal modes need owners",
+			req:     CreateBillItemRequest{Name: "Bread", Amount: decimal.NewFromInt(3), Quantity: decimal.NewFromInt(1), SplitMode: "percent"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.req.normalize()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("normalize() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && tt.req.SplitMode != tt.wantMode {
+				t.Errorf("normalize() mode = %s, want %s", tt.req.SplitMode, tt.wantMode)
+			}
+			if !tt.wantErr && tt.req.Unit == "" {
+				t.Error("normalize() left unit empty")
+			}
+		})
+	}
+}
+
+func TestLineTotal(t *testing.T) {
+	tests := []struct {
+		name     string
+		amount   string
+		quantity string
+		want     string
+	}{
+		{name: "Whole units", amount: "2.50", quantity: "4", want: "10"},
+		{name: "Apples by the kilogram", amount: "3.99", quantity: "1.37", want: "5.47"},
+		{name: "Rounds half away from zero", amount: "0.25", quantity: "0.5", want: "0.13"},
+		{name: "Milk by the litre", amount: "1.29", quantity: "2.25", want: "2.9"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := lineTotal(decimal.RequireFromString(tt.amount), decimal.RequireFromString(tt.quantity))
+			if !got.Equal(decimal.RequireFromString(tt.want)) {
+				t.Errorf("lineTotal() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreateBillOnBehalfOfMember(t *testing.T) {
+	s := newTestServices(t)
+	admin := s.createUser(t, "alice")
+	creator := s.createUser(t, "bob")
+	payer := s.createUser(t, "carol")
+	outsider := s.createUser(t, "dave")
+	group := s.createGroup(t, admin, creator, payer)
+
+	req := CreateBillRequest{
+		GroupID:     group.ID,
+		Title:       "Groceries",
+		TotalAmount: decimal.NewFromInt(12),
+		PaidByID:    payer.ID,
+		Items: []CreateBillItemRequest{
+			{Name: "Bread", Amount: decimal.NewFromInt(12), IsShared: true},
+		},
+	}
+
+	bill, err := s.bill.CreateBill(creator.ID, req)
+	if err != nil {
+		t.Fatalf("CreateBill() error = %v", err)
+	}
+	if bill.PaidByID != payer.ID {
+		t.Errorf("PaidByID = %d, want payer %d", bill.PaidByID, payer.ID)
+	}
+	if bill.CreatedByID != creator.ID {
+		t.Errorf("CreatedByID = %d, want creator %d", bill.CreatedByID, creator.ID)
+	}
+
+	// The member who entered the bill keeps edit rights, the payer doesn't get them
+	update := UpdateBillRequest{Title: "Weekly groceries", TotalAmount: bill.TotalAmount, BillDate: bill.BillDate}
+	if _, err := s.bill.UpdateBill(bill.ID, creator.ID, update); err != nil {
+		t.Errorf("UpdateBill() by creator error = %v", err)
+	}
+	if _, err := s.bill.UpdateBill(bill.ID, payer.ID, update); err == nil {
+		t.Error("UpdateBill() by payer succeeded, want it refused")
+	}
+	if _, err := s.bill.UpdateBill(bill.ID, admin.ID, update); err != nil {
+		t.Errorf("UpdateBill() by admin error = %v", err)
+	}
+
+	// Only members can be recorded as the payer
+	req.PaidByID = outsider.ID
+	if _, err := s.bill.CreateBill(creator.ID, req); err == nil || err.Error() != "payer is not a member of this group" {
+		t.Errorf("CreateBill() with non-member payer error = %v, want payer is not a member of this group", err)
+	}
+}
+
+func TestCreateBillDefaultsPayerToCaller(t *testing.T) {
+	s := newTestServices(t)
+	admin := s.createUser(t, "alice")
+	member := s.createUser(t, "bob")
+	group := s.createGroup(t, admin, member)
+
+	bill, err := s.bill.CreateBill(member.ID, CreateBillRequest{
+		GroupID:     group.ID,
+		Title:       "Milk",
+		TotalAmount: decimal.NewFromInt(3),
+		Items: []CreateBillItemRequest{
+			{Name: "Milk", Amount: decimal.NewFromInt(3), OwnerIDs: []uint{member.ID}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("CreateBill() error = %v", err)
+	}
+	if bill.PaidByID != member.ID || bill.CreatedByID != member.ID {
+		t.Errorf("PaidByID = %d, CreatedByID = %d, want both %d", bill.PaidByID, bill.CreatedByID, member.ID)
+	}
+}
@@ -0,0 +1,568 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/JacksonYuKe/sharedcart-backend/internal/database"
+	"github.com/JacksonYuKe/sharedcart-backend/internal/models"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+// DebtOffsetService nets what people owe each other across the groups they share
+type DebtOffsetService struct {
+	db                *gorm.DB
+	groupService      *GroupService
+	settlementService *SettlementService
+	ledgerService     *LedgerService
+}
+
+// NewDebtOffsetService creates a new debt offset service
+func NewDebtOffsetService(groupService *GroupService, settlementService *SettlementService, ledgerService *LedgerService) *DebtOffsetService {
+	return &DebtOffsetService{
+		db:                database.DB,
+		groupService:      groupService,
+		settlementService: settlementService,
+		ledgerService:     ledgerService,
+	}
+}
+
+// PairGroupBalance is what a user and one other person owe each other within a single group
+type PairGroupBalance struct {
+	GroupID   uint            `json:"group_id"`
+	GroupName string          `json:"group_name"`
+	Currency  string          `json:"currency"`
+	Amount    decimal.Decimal `json:"amount"` // Positive when the other person owes the user
+}
+
+// CurrencyAmount is an amount in a single currency
+type CurrencyAmount struct {
+	Currency string          `json:"currency"`
+	Amount   decimal.Decimal `json:"amount"`
+}
+
+// CounterpartyBalance is everything a user and one other person owe each other across groups
+type CounterpartyBalance struct {
+	UserID   uint               `json:"user_id"`
+	UserName string             `json:"user_name"`
+	Groups   []PairGroupBalance `json:"groups"`
+	Net      []CurrencyAmount   `json:"net"` // Per currency, positive when the other person owes the user
+}
+
+// NettingProposal suggests cancelling a debt in one group against a credit with the same person in another
+type NettingProposal struct {
+	CounterpartyID   uint            `json:"counterparty_id"`
+	CounterpartyName string          `json:"counterparty_name"`
+	DebtGroupID      uint            `json:"debt_group_id"`   // Group where the user owes the counterparty
+	CreditGroupID    uint            `json:"credit_group_id"` // Group where the counterparty owes the user
+	Currency         string          `json:"currency"`
+	Amount           decimal.Decimal `json:"amount"`
+}
+
+// SkippedGroup is a group left out of a user's balances because no payments could be planned in it
+type SkippedGroup struct {
+	GroupID   uint   `json:"group_id"`
+	GroupName string `json:"group_name"`
+	Reason    string `json:"reason"`
+}
+
+// UserBalancesResult represents a user's balances with everyone they share a group with
+type UserBalancesResult struct {
+	UserID         uint                  `json:"user_id"`
+	Counterparties []CounterpartyBalance `json:"counterparties"`
+	Proposals      []NettingProposal     `json:"proposals"`
+	SkippedGroups  []SkippedGroup        `json:"skipped_groups,omitempty"` // Groups whose payment preferences rule out every plan
+}
+
+// ProposeDebtOffsetRequest represents a cross-group offset proposal
+type ProposeDebtOffsetRequest struct {
+	CounterpartyID uint            `json:"counterparty_id" binding:"required"`
+	DebtGroupID    uint            `json:"debt_group_id" binding:"required"`   // Group where the caller owes the counterparty
+	CreditGroupID  uint            `json:"credit_group_id" binding:"required"` // Group where the counterparty owes the caller
+	Amount         decimal.Decimal `json:"amount"`                             // Optional; defaults to the largest amount that can be offset
+}
+
+// GetUserBalances aggregates what the user owes and is owed by each person across all their groups,
+// and proposes offsets between groups
+func (s *DebtOffsetService) GetUserBalances(userID uint) (*UserBalancesResult, error) {
+	counterparties, skipped, err := s.counterpartyBalances(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &UserBalancesResult{
+		UserID:         userID,
+		Counterparties: make([]CounterpartyBalance, 0, len(counterparties)),
+		SkippedGroups:  skipped,
+	}
+	for _, counterparty := range counterparties {
+		result.Counterparties = append(result.Counterparties, *counterparty)
+	}
+	sort.Slice(result.Counterparties, func(i, j int) bool {
+		return result.Counterparties[i].UserID < result.Counterparties[j].UserID
+	})
+	result.Proposals = proposeNetting(result.Counterparties)
+
+	return result, nil
+}
+
+// counterpartyBalances splits each group's suggested payments into what the user and every other
+// member owe each other, keyed by the other member. Groups where no payments can be planned are
+// left out and returned separately, so they don't hide the balances in every other group.
+func (s *DebtOffsetService) counterpartyBalances(userID uint) (map[uint]*CounterpartyBalance, []SkippedGroup, error) {
+	groups, err := s.groupService.GetUserGroups(userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].ID < groups[j].ID
+	})
+
+	counterparties := make(map[uint]*CounterpartyBalance)
+	var skipped []SkippedGroup
+	for _, group := range groups {
+		balances, err := s.settlementService.GetGroupBalances(group.ID, userID)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to get balances for group %d: %w", group.ID, err)
+		}
+		if balances.PlanError != "" {
+			skipped = append(skipped, SkippedGroup{GroupID: group.ID, GroupName: group.Name, Reason: balances.PlanError})
+			continue
+		}
+
+		amounts := make(map[uint]decimal.Decimal)
+		names := make(map[uint]string)
+		for _, trans := range balances.Transactions {
+			if trans.FromUserID == userID {
+				amounts[trans.ToUserID] = amounts[trans.ToUserID].Sub(trans.Amount)
+				names[trans.ToUserID] = trans.ToUserName
+			} else if trans.ToUserID == userID {
+				amounts[trans.FromUserID] = amounts[trans.FromUserID].Add(trans.Amount)
+				names[trans.FromUserID] = trans.FromUserName
+			}
+		}
+
+		for otherID, amount := range amounts {
+			if amount.IsZero() {
+				continue
+			}
+			counterparty, exists := counterparties[otherID]
+			if !exists {
+				counterparty = &CounterpartyBalance{UserID: otherID, UserName: names[otherID]}
+				counterparties[otherID] = counterparty
+			}
+			counterparty.Groups = append(counterparty.Groups, PairGroupBalance{
+				GroupID:   group.ID,
+				GroupName: group.Name,
+				Currency:  balances.Currency,
+				Amount:    amount,
+			})
+		}
+	}
+
+	for _, counterparty := range counterparties {
+		net := make(map[string]decimal.Decimal)
+		for _, entry := range counterparty.Groups {
+			net[entry.Currency] = net[entry.Currency].Add(entry.Amount)
+		}
+		for currency, amount := range net {
+			counterparty.Net = append(counterparty.Net, CurrencyAmount{Currency: currency, Amount: amount})
+		}
+		sort.Slice(counterparty.Net, func(i, j int) bool {
+			return counterparty.Net[i].Currency < counterparty.Net[j].Currency
+		})
+	}
+
+	return counterparties, skipped, nil
+}
+
+// proposeNetting pairs each debt to a person with credits from the same person in other groups
+// of the same currency, largest first
+func proposeNetting(counterparties []CounterpartyBalance) []NettingProposal {
+	type groupAmount struct {
+		groupID uint
+		amount  decimal.Decimal
+	}
+	largestFirst := func(list []groupAmount) {
+		sort.Slice(list, func(i, j int) bool {
+			if !list[i].amount.Equal(list[j].amount) {
+				return list[i].amount.GreaterThan(list[j].amount)
+			}
+			return list[i].groupID < list[j].groupID
+		})
+	}
+
+	var proposals []NettingProposal
+	for _, counterparty := range counterparties {
+		debts := make(map[string][]groupAmount)
+		credits := make(map[string][]groupAmount)
+		var currencies []string
+		seen := make(map[string]bool)
+		for _, entry := range counterparty.Groups {
+			if !seen[entry.Currency] {
+				seen[entry.Currency] = true
+				currencies = append(currencies, entry.Currency)
+			}
+			if entry.Amount.IsNegative() {
+				debts[entry.Currency] = append(debts[entry.Currency], groupAmount{entry.GroupID, entry.Amount.Neg()})
+			} else if entry.Amount.IsPositive() {
+				credits[entry.Currency] = append(credits[entry.Currency], groupAmount{entry.GroupID, entry.Amount})
+			}
+		}
+		sort.Strings(currencies)
+
+		for _, currency := range currencies {
+			debtList, creditList := debts[currency], credits[currency]
+			largestFirst(debtList)
+			largestFirst(creditList)
+
+			i, j := 0, 0
+			for i < len(debtList) && j < len(creditList) {
+				amount := decimal.Min(debtList[i].amount, creditList[j].amount)
+				proposals = append(proposals, NettingProposal{
+					CounterpartyID:   counterparty.UserID,
+					CounterpartyName: counterparty.UserName,
+					DebtGroupID:      debtList[i].groupID,
+					CreditGroupID:    creditList[j].groupID,
+					Currency:         currency,
+					Amount:           amount,
+				})
+
+				debtList[i].amount = debtList[i].amount.Sub(amount)
+				creditList[j].amount = creditList[j].amount.Sub(amount)
+				if !debtList[i].amount.IsPositive() {
+					i++
+				}
+				if !creditList[j].amount.IsPositive() {
+					j++
+				}
+			}
+		}
+	}
+
+	return proposals
+}
+
+// availableOffset returns how much of the user's debt in one group can be cancelled against the
+// counterparty's debt in another, along with the shared currency. It locks both people's balances
+// in both groups until tx ends, so concurrent offsets between them are checked one after another.
+func (s *DebtOffsetService) availableOffset(tx *gorm.DB, userID, counterpartyID, debtGroupID, creditGroupID uint) (decimal.Decimal, string, error) {
+	limit, err := s.lockOffsetBalances(tx, userID, counterpartyID, debtGroupID, creditGroupID)
+	if err != nil {
+		return decimal.Zero, "", err
+	}
+
+	counterparties, _, err := s.counterpartyBalances(userID)
+	if err != nil {
+		return decimal.Zero, "", err
+	}
+
+	var debt, credit *PairGroupBalance
+	if counterparty, exists := counterparties[counterpartyID]; exists {
+		for i, entry := range counterparty.Groups {
+			if entry.GroupID == debtGroupID && entry.Amount.IsNegative() {
+				debt = &counterparty.Groups[i]
+			}
+			if entry.GroupID == creditGroupID && entry.Amount.IsPositive() {
+				credit = &counterparty.Groups[i]
+			}
+		}
+	}
+
+	if debt == nil || credit == nil {
+		return decimal.Zero, "", nil
+	}
+	if debt.Currency != credit.Currency {
+		return decimal.Zero, "", errors.New("debt and credit groups use different currencies")
+	}
+
+	return decimal.Min(debt.Amount.Neg(), credit.Amount, limit), debt.Currency, nil
+}
+
+// lockOffsetBalances locks the user's and the counterparty's running balances in both groups and
+// returns the most those balances allow to be offset
+func (s *DebtOffsetService) lockOffsetBalances(tx *gorm.DB, userID, counterpartyID, debtGroupID, creditGroupID uint) (decimal.Decimal, error) {
+	type memberKey struct {
+		groupID, userID uint
+	}
+	keys := []memberKey{
+		{debtGroupID, userID},
+		{debtGroupID, counterpartyID},
+		{creditGroupID, userID},
+		{creditGroupID, counterpartyID},
+	}
+
+	// Always lock in the same order, so two offsets between the same people can't deadlock
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].groupID != keys[j].groupID {
+			return keys[i].groupID < keys[j].groupID
+		}
+		return keys[i].userID < keys[j].userID
+	})
+
+	balances := make(map[memberKey]decimal.Decimal, len(keys))
+	for _, key := range keys {
+		balance, err := s.ledgerService.balanceOf(tx, key.groupID, key.userID)
+		if err != nil {
+			return decimal.Zero, err
+		}
+		balances[key] = balance
+	}
+
+	return offsetLimit(
+		balances[memberKey{debtGroupID, userID}],
+		balances[memberKey{debtGroupID, counterpartyID}],
+		balances[memberKey{creditGroupID, userID}],
+		balances[memberKey{creditGroupID, counterpartyID}],
+	), nil
+}
+
+// offsetLimit returns the most that can be offset without cancelling more debt than exists: the user
+// has to owe and the counterparty be owed in the debt group, and the other way round in the credit group
+func offsetLimit(userDebtGroup, counterpartyDebtGroup, userCreditGroup, counterpartyCreditGroup decimal.Decimal) decimal.Decimal {
+	limit := decimal.Min(userDebtGroup.Neg(), counterpartyDebtGroup, userCreditGroup, counterpartyCreditGroup.Neg())
+	return decimal.Max(limit, decimal.Zero)
+}
+
+// ProposeDebtOffset asks a counterparty to cancel a debt in one group against a credit in another
+func (s *DebtOffsetService) ProposeDebtOffset(userID uint, req ProposeDebtOffsetRequest) (*models.DebtOffset, error) {
+	if req.CounterpartyID == userID {
+		return nil, errors.New("cannot offset debts with yourself")
+	}
+
+	if req.DebtGroupID == req.CreditGroupID {
+		return nil, errors.New("debt and credit groups must differ")
+	}
+
+	// Start transaction
+	tx := s.db.Begin()
+
+	available, currency, err := s.availableOffset(tx, userID, req.CounterpartyID, req.DebtGroupID, req.CreditGroupID)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if !available.IsPositive() {
+		tx.Rollback()
+		return nil, errors.New("no offsettable debts between these groups")
+	}
+
+	amount := req.Amount
+	if amount.IsZero() {
+		amount = available
+	}
+	if !amount.IsPositive() {
+		tx.Rollback()
+		return nil, errors.New("offset amount must be positive")
+	}
+	if amount.GreaterThan(available) {
+		tx.Rollback()
+		return nil, errors.New("offset exceeds offsettable amount")
+	}
+
+	if err := s.checkNoOpenTransactions(tx, userID, req.CounterpartyID, req.DebtGroupID, req.CreditGroupID); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	// One open proposal per pair of groups keeps the amounts from being offered twice
+	var pending int64
+	if err := tx.Model(&models.DebtOffset{}).
+		Where("proposer_id = ? AND counterparty_id = ? AND debt_group_id = ? AND credit_group_id = ? AND status = ?",
+			userID, req.CounterpartyID, req.DebtGroupID, req.CreditGroupID, "pending").
+		Count(&pending).Error; err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("failed to check pending debt offsets: %w", err)
+	}
+	if pending > 0 {
+		tx.Rollback()
+		return nil, errors.New("an offset between these groups is already pending")
+	}
+
+	offset := models.DebtOffset{
+		ProposerID:     userID,
+		CounterpartyID: req.CounterpartyID,
+		DebtGroupID:    req.DebtGroupID,
+		CreditGroupID:  req.CreditGroupID,
+		Currency:       currency,
+		Amount:         amount,
+		Status:         "pending",
+	}
+
+	if err := tx.Create(&offset).Error; err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("failed to create debt offset: %w", err)
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return s.loadDebtOffset(offset.ID)
+}
+
+// GetDebtOffsets lists the offsets the user proposed or was asked to accept
+func (s *DebtOffsetService) GetDebtOffsets(userID uint, status string) ([]models.DebtOffset, error) {
+	query := s.db.Where("proposer_id = ? OR counterparty_id = ?", userID, userID)
+
+	// Filter by status if provided
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var offsets []models.DebtOffset
+	err := query.
+		Preload("Proposer").
+		Preload("Counterparty").
+		Preload("DebtGroup").
+		Preload("CreditGroup").
+		Order("created_at DESC").
+		Find(&offsets).Error
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to get debt offsets: %w", err)
+	}
+
+	return offsets, nil
+}
+
+// AcceptDebtOffset records a pending offset in both groups' balances
+func (s *DebtOffsetService) AcceptDebtOffset(offsetID, userID uint) (*models.DebtOffset, error) {
+	offset, err := s.pendingOffsetFor(offsetID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Start transaction
+	tx := s.db.Begin()
+
+	// Accept only if the offset is still pending, so a repeated accept can't apply it twice
+	now := time.Now()
+	result := tx.Model(&models.DebtOffset{}).
+		Where("id = ? AND status = ?", offset.ID, "pending").
+		Updates(map[string]interface{}{"status": "accepted", "responded_at": now})
+	if result.Error != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("failed to update debt offset: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		tx.Rollback()
+		return nil, errors.New("debt offset is not pending")
+	}
+
+	// Balances may have moved since the proposal, not least through other offsets between the same people
+	available, _, err := s.availableOffset(tx, offset.ProposerID, offset.CounterpartyID, offset.DebtGroupID, offset.CreditGroupID)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if offset.Amount.GreaterThan(available) {
+		tx.Rollback()
+		return nil, errors.New("debt offset is no longer valid")
+	}
+
+	if err := s.checkNoOpenTransactions(tx, offset.ProposerID, offset.CounterpartyID, offset.DebtGroupID, offset.CreditGroupID); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	// The offset works like each of them paying the other in the group where they owe
+	if err := s.ledgerService.recordPayment(tx, offset.DebtGroupID, offset.ProposerID, offset.CounterpartyID, offset.Amount); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := s.ledgerService.recordPayment(tx, offset.CreditGroupID, offset.CounterpartyID, offset.ProposerID, offset.Amount); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return s.loadDebtOffset(offset.ID)
+}
+
+// DeclineDebtOffset rejects a pending offset without touching any balances
+func (s *DebtOffsetService) DeclineDebtOffset(offsetID, userID uint) (*models.DebtOffset, error) {
+	offset, err := s.pendingOffsetFor(offsetID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	result := s.db.Model(&models.DebtOffset{}).
+		Where("id = ? AND status = ?", offset.ID, "pending").
+		Updates(map[string]interface{}{"status": "declined", "responded_at": now})
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to update debt offset: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, errors.New("debt offset is not pending")
+	}
+
+	return s.loadDebtOffset(offset.ID)
+}
+
+// pendingOffsetFor loads a pending offset that the user is the counterparty of
+func (s *DebtOffsetService) pendingOffsetFor(offsetID, userID uint) (*models.DebtOffset, error) {
+	var offset models.DebtOffset
+	if err := s.db.First(&offset, offsetID).Error; err != nil {
+		return nil, errors.New("debt offset not found")
+	}
+
+	if offset.CounterpartyID != userID {
+		return nil, errors.New("only the counterparty can respond to a debt offset")
+	}
+
+	if offset.Status != "pending" {
+		return nil, errors.New("debt offset is not pending")
+	}
+
+	return &offset, nil
+}
+
+// checkNoOpenTransactions refuses offsets while the two people still have unpaid settlement
+// transactions with each other in either group, since paying those later would count the same
+// debt twice
+func (s *DebtOffsetService) checkNoOpenTransactions(db *gorm.DB, userID, counterpartyID, debtGroupID, creditGroupID uint) error {
+	var count int64
+	err := db.Model(&models.SettlementTransaction{}).
+		Joins("JOIN settlements ON settlements.id = settlement_transactions.settlement_id").
+		Where("settlements.group_id IN ? AND settlements.status IN ? AND settlements.deleted_at IS NULL",
+			[]uint{debtGroupID, creditGroupID}, []string{"pending", "confirmed"}).
+		Where("settlement_transactions.status IN ?", []string{"pending", "partially_paid"}).
+		Where("(settlement_transactions.from_user_id = ? AND settlement_transactions.to_user_id = ?) OR (settlement_transactions.from_user_id = ? AND settlement_transactions.to_user_id = ?)",
+			userID, counterpartyID, counterpartyID, userID).
+		Count(&count).Error
+
+	if err != nil {
+		return fmt.Errorf("failed to check settlement transactions: %w", err)
+	}
+
+	if count > 0 {
+		return errors.New("unpaid settlement transactions exist between these members")
+	}
+
+	return nil
+}
+
+// loadDebtOffset loads an offset with the people and groups it involves
+func (s *DebtOffsetService) loadDebtOffset(offsetID uint) (*models.DebtOffset, error) {
+	var offset models.DebtOffset
+	err := s.db.
+		Preload("Proposer").
+		Preload("Counterparty").
+		Preload("DebtGroup").
+		Preload("CreditGroup").
+		First(&offset, offsetID).Error
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to load debt offset data: %w", err)
+	}
+
+	return &offset, nil
+}
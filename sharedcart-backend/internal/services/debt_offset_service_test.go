@@ -0,0 +1,193 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/JacksonYuKe/sharedcart-backend/internal/models"
+	"github.com/shopspring/decimal"
+)
+
+func TestProposeNetting(t *testing.T) {
+	counterparties := []CounterpartyBalance{
+		{
+			UserID: 2,
+			Groups: []PairGroupBalance{
+				{GroupID: 1, Currency: "USD", Amount: decimal.RequireFromString("-30.00")},
+				{GroupID: 2, Currency: "USD", Amount: decimal.RequireFromString("20.00")},
+				{GroupID: 3, Currency: "USD", Amount: decimal.RequireFromString("15.00")},
+				{GroupID: 4, Currency: "EUR", Amount: decimal.RequireFromString("-5.00")},
+				{GroupID: 5, Currency: "EUR", Amount: decimal.RequireFromString("8.00")},
+			},
+		},
+		{
+			// Only owed in one direction, so there is nothing to net
+			UserID: 3,
+			Groups: []PairGroupBalance{
+				{GroupID: 1, Currency: "USD", Amount: decimal.RequireFromString("-10.00")},
+				{GroupID: 2, Currency: "USD", Amount: decimal.RequireFromString("-4.00")},
+			},
+		},
+	}
+
+	want := []NettingProposal{
+		{CounterpartyID: 2, DebtGroupID: 4, CreditGroupID: 5, Currency: "EUR", Amount: decimal.RequireFromString("5.00")},
+		{CounterpartyID: 2, DebtGroupID: 1, CreditGroupID: 2, Currency: "USD", Amount: decimal.RequireFromString("20.00")},
+		{CounterpartyID: 2, DebtGroupID: 1, CreditGroupID: 3, Currency: "USD", Amount: decimal.RequireFromString("10.00")},
+	}
+
+	got := proposeNetting(counterparties)
+	if len(got) != len(want) {
+		t.Fatalf("got %d proposals, want %d: %+v", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i].CounterpartyID != want[i].CounterpartyID ||
+			got[i].DebtGroupID != want[i].DebtGroupID ||
+			got[i].CreditGroupID != want[i].CreditGroupID ||
+			got[i].Currency != want[i].Currency ||
+			!got[i].Amount.Equal(want[i].Amount) {
+			t.Errorf("proposal %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestOffsetLimit(t *testing.T) {
+	tests := []struct {
+		name                                                                           string
+		userDebtGroup, counterpartyDebtGroup, userCreditGroup, counterpartyCreditGroup string
+		want                                                                           string
+	}{
+		{name: "Smallest of the four", userDebtGroup: "-30", counterpartyDebtGroup: "25", userCreditGroup: "40", counterpartyCreditGroup: "-20", want: "20"},
+		{name: "User owes nothing in the debt group", userDebtGroup: "0", counterpartyDebtGroup: "25", userCreditGroup: "40", counterpartyCreditGroup: "-20", want: "0"},
+		{name: "Counterparty is owed nothing in the credit group", userDebtGroup: "-30", counterpartyDebtGroup: "25", userCreditGroup: "-5", counterpartyCreditGroup: "-20", want: "0"},
+		{name: "Counterparty owes in the debt group", userDebtGroup: "-30", counterpartyDebtGroup: "-10", userCreditGroup: "40", counterpartyCreditGroup: "-20", want: "0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := offsetLimit(
+				decimal.RequireFromString(tt.userDebtGroup),
+				decimal.RequireFromString(tt.counterpartyDebtGroup),
+				decimal.RequireFromString(tt.userCreditGroup),
+				decimal.RequireFromString(tt.counterpartyCreditGroup),
+			)
+			if !got.Equal(decimal.RequireFromString(tt.want)) {
+				t.Errorf("offsetLimit() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAcceptDebtOffset(t *testing.T) {
+	s := newTestServices(t)
+	alice := s.createUser(t, "alice")
+	bob := s.createUser(t, "bob")
+
+	// Alice owes bob 10 in one group and bob owes alice 10 in the other
+	debtGroup := s.createGroup(t, alice, bob)
+	s.createFinalizedBill(t, debtGroup.ID, bob, "20.00")
+	creditGroup := s.createGroup(t, alice, bob)
+	s.createFinalizedBill(t, creditGroup.ID, alice, "20.00")
+
+	offset, err := s.debtOffset.ProposeDebtOffset(alice.ID, ProposeDebtOffsetRequest{
+		CounterpartyID: bob.ID,
+		DebtGroupID:    debtGroup.ID,
+		CreditGroupID:  creditGroup.ID,
+	})
+	if err != nil {
+		t.Fatalf("ProposeDebtOffset() error = %v", err)
+	}
+	if !offset.Amount.Equal(decimal.RequireFromString("10")) || offset.Currency != "USD" {
+		t.Errorf("proposed %s %s, want 10 USD", offset.Amount, offset.Currency)
+	}
+
+	_, err = s.debtOffset.ProposeDebtOffset(alice.ID, ProposeDebtOffsetRequest{
+		CounterpartyID: bob.ID,
+		DebtGroupID:    debtGroup.ID,
+		CreditGroupID:  creditGroup.ID,
+	})
+	if err == nil || err.Error() != "an offset between these groups is already pending" {
+		t.Errorf("second ProposeDebtOffset() error = %v, want an offset between these groups is already pending", err)
+	}
+
+	// Bob proposes the same offset the other way round, which is not a duplicate of alice's
+	mirror, err := s.debtOffset.ProposeDebtOffset(bob.ID, ProposeDebtOffsetRequest{
+		CounterpartyID: alice.ID,
+		DebtGroupID:    creditGroup.ID,
+		CreditGroupID:  debtGroup.ID,
+	})
+	if err != nil {
+		t.Fatalf("mirrored ProposeDebtOffset() error = %v", err)
+	}
+
+	if _, err := s.debtOffset.AcceptDebtOffset(offset.ID, alice.ID); err == nil || err.Error() != "only the counterparty can respond to a debt offset" {
+		t.Errorf("AcceptDebtOffset() by the proposer error = %v, want only the counterparty can respond to a debt offset", err)
+	}
+
+	accepted, err := s.debtOffset.AcceptDebtOffset(offset.ID, bob.ID)
+	if err != nil {
+		t.Fatalf("AcceptDebtOffset() error = %v", err)
+	}
+	if accepted.Status != "accepted" {
+		t.Errorf("status = %s, want accepted", accepted.Status)
+	}
+	for _, group := range []models.Group{debtGroup, creditGroup} {
+		for _, user := range []models.User{alice, bob} {
+			if balance := s.ledgerBalance(t, group.ID, user); !balance.IsZero() {
+				t.Errorf("%s's balance in group %d = %s, want 0", user.Name, group.ID, balance)
+			}
+		}
+	}
+
+	// The debts are gone, so accepting the mirrored offset would cancel them a second time
+	if _, err := s.debtOffset.AcceptDebtOffset(mirror.ID, alice.ID); err == nil || err.Error() != "debt offset is no longer valid" {
+		t.Errorf("AcceptDebtOffset() of the mirrored offset error = %v, want debt offset is no longer valid", err)
+	}
+	if balance := s.ledgerBalance(t, debtGroup.ID, alice); !balance.IsZero() {
+		t.Errorf("alice's balance after the refused offset = %s, want 0", balance)
+	}
+	var stored models.DebtOffset
+	if err := s.db.First(&stored, mirror.ID).Error; err != nil {
+		t.Fatalf("failed to reload debt offset: %v", err)
+	}
+	if stored.Status != "pending" {
+		t.Errorf("mirrored offset status = %s, want pending", stored.Status)
+	}
+
+	if _, err := s.debtOffset.AcceptDebtOffset(offset.ID, bob.ID); err == nil || err.Error() != "debt offset is not pending" {
+		t.Errorf("repeated AcceptDebtOffset() error = %v, want debt offset is not pending", err)
+	}
+}
+
+func TestGetUserBalancesSkipsUnplannableGroups(t *testing.T) {
+	s := newTestServices(t)
+	alice := s.createUser(t, "alice")
+	bob := s.createUser(t, "bob")
+	carol := s.createUser(t, "carol")
+
+	plannable := s.createGroup(t, alice, bob)
+	s.createFinalizedBill(t, plannable.ID, bob, "20.00")
+
+	// Bob is the only one owed anything, so carol refusing to pay him rules out every plan
+	blocked := s.createGroup(t, alice, bob, carol)
+	s.createFinalizedBill(t, blocked.ID, bob, "30.00")
+	if _, err := s.preference.SetPaymentPreference(blocked.ID, carol.ID, SetPaymentPreferenceRequest{TargetUserID: bob.ID, Kind: "block"}); err != nil {
+		t.Fatalf("SetPaymentPreference() error = %v", err)
+	}
+
+	result, err := s.debtOffset.GetUserBalances(alice.ID)
+	if err != nil {
+		t.Fatalf("GetUserBalances() error = %v", err)
+	}
+
+	if len(result.SkippedGroups) != 1 || result.SkippedGroups[0].GroupID != blocked.ID ||
+		result.SkippedGroups[0].Reason != "payment preferences make settlement impossible" {
+		t.Errorf("SkippedGroups = %+v, want group %d with payment preferences make settlement impossible", result.SkippedGroups, blocked.ID)
+	}
+	if len(result.Counterparties) != 1 || result.Counterparties[0].UserID != bob.ID {
+		t.Fatalf("Counterparties = %+v, want only bob", result.Counterparties)
+	}
+	groups := result.Counterparties[0].Groups
+	if len(groups) != 1 || groups[0].GroupID != plannable.ID || !groups[0].Amount.Equal(decimal.RequireFromString("-10")) {
+		t.Errorf("bob's groups = %+v, want alice owing 10 in group %d", groups, plannable.ID)
+	}
+}
@@ -0,0 +1,244 @@
+package services
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/JacksonYuKe/sharedcart-backend/internal/database"
+	"github.com/JacksonYuKe/sharedcart-backend/internal/models"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ExchangeRateService manages the exchange-rate table used to convert bills into group currencies
+type ExchangeRateService struct {
+	db *gorm.DB
+}
+
+// NewExchangeRateService creates a new exchange rate service
+func NewExchangeRateService() *ExchangeRateService {
+	return &ExchangeRateService{
+		db: database.DB,
+	}
+}
+
+// dateLayout is the format of exchange rate effective dates
+const dateLayout = "2006-01-02"
+
+// ExchangeRateRequest represents one exchange rate input
+type ExchangeRateRequest struct {
+	BaseCurrency  string          `json:"base_currency" binding:"required,len=3,uppercase"`
+	QuoteCurrency string          `json:"quote_currency" binding:"required,len=3,uppercase"`
+	Rate          decimal.Decimal `json:"rate" binding:"required"`                               // Units of quote currency per unit of base currency
+	EffectiveDate string          `json:"effective_date" binding:"required,datetime=2006-01-02"` // YYYY-MM-DD
+}
+
+// SetExchangeRatesRequest represents a batch of exchange rates to create or replace
+type SetExchangeRatesRequest struct {
+	Rates []ExchangeRateRequest `json:"rates" binding:"required,min=1,dive"`
+}
+
+// AppliedExchangeRate records the rate used to convert a bill into the group currency
+type AppliedExchangeRate struct {
+	BillID        uint            `json:"bill_id"`
+	FromCurrency  string          `json:"from_currency"`
+	ToCurrency    string          `json:"to_currency"`
+	Rate          decimal.Decimal `json:"rate"`
+	EffectiveDate string          `json:"effective_date"`
+}
+
+// MissingExchangeRateError reports that no rate was in effect for a conversion
+type MissingExchangeRateError struct {
+	FromCurrency string `json:"from_currency"`
+	ToCurrency   string `json:"to_currency"`
+	Date         string `json:"date"`
+}
+
+// Error implements the error interface
+func (e *MissingExchangeRateError) Error() string {
+	return fmt.Sprintf("no exchange rate from %s to %s on %s", e.FromCurrency, e.ToCurrency, e.Date)
+}
+
+// GetExchangeRates lists exchange rates, optionally for a single currency pair
+func (s *ExchangeRateService) GetExchangeRates(base, quote string) ([]models.ExchangeRate, error) {
+	query := s.db.Model(&models.ExchangeRate{})
+	if base != "" {
+		query = query.Where("base_currency = ?", base)
+	}
+	if quote != "" {
+		query = query.Where("quote_currency = ?", quote)
+	}
+
+	var rates []models.ExchangeRate
+	if err := query.Order("base_currency, quote_currency, effective_date DESC").Find(&rates).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch exchange rates: %w", err)
+	}
+
+	return rates, nil
+}
+
+// SetExchangeRates creates rates, replacing any existing rate for the same pair and date
+func (s *ExchangeRateService) SetExchangeRates(reqs []ExchangeRateRequest) ([]models.ExchangeRate, error) {
+	rates := make([]models.ExchangeRate, 0, len(reqs))
+	for _, req := range reqs {
+		if req.BaseCurrency == req.QuoteCurrency {
+			return nil, errors.New("base and quote currency must differ")
+		}
+		if !req.Rate.IsPositive() {
+			return nil, errors.New("exchange rate must be positive")
+		}
+		effectiveDate, err := time.Parse(dateLayout, req.EffectiveDate)
+		if err != nil {
+			return nil, errors.New("effective date must be formatted as YYYY-MM-DD")
+		}
+
+		rates = append(rates, models.ExchangeRate{
+			BaseCurrency:  req.BaseCurrency,
+			QuoteCurrency: req.QuoteCurrency,
+			Rate:          req.Rate,
+			EffectiveDate: effectiveDate,
+		})
+	}
+
+	err := s.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "base_currency"}, {Name: "quote_currency"}, {Name: "effective_date"}},
+		DoUpdates: clause.AssignmentColumns([]string{"rate", "updated_at"}),
+	}).Create(&rates).Error
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to save exchange rates: %w", err)
+	}
+
+	return rates, nil
+}
+
+// DeleteExchangeRate removes a single exchange rate
+func (s *ExchangeRateService) DeleteExchangeRate(rateID uint) error {
+	result := s.db.Delete(&models.ExchangeRate{}, rateID)
+	if result.Error != nil {
+		return fmt.Errorf("failed to delete exchange rate: %w", result.Error)
+	}
+
+	if result.RowsAffected == 0 {
+		return errors.New("exchange rate not found")
+	}
+
+	return nil
+}
+
+// ImportFile loads exchange rates from a CSV file with the columns
+// base_currency, quote_currency, rate, effective_date and returns how many were saved
+func (s *ExchangeRateService) ImportFile(path string) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open exchange rate file: %w", err)
+	}
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = 4
+	reader.TrimLeadingSpace = true
+
+	var reqs []ExchangeRateRequest
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to read exchange rate file: %w", err)
+		}
+
+		// Skip the optional header row
+		if line == 1 && record[0] == "base_currency" {
+			continue
+		}
+
+		rate, err := decimal.NewFromString(record[2])
+		if err != nil {
+			return 0, fmt.Errorf("line %d: invalid rate %q", line, record[2])
+		}
+		reqs = append(reqs, ExchangeRateRequest{
+			BaseCurrency:  strings.ToUpper(record[0]),
+			QuoteCurrency: strings.ToUpper(record[1]),
+			Rate:          rate,
+			EffectiveDate: record[3],
+		})
+	}
+
+	if len(reqs) == 0 {
+		return 0, nil
+	}
+
+	if _, err := s.SetExchangeRates(reqs); err != nil {
+		return 0, err
+	}
+	return len(reqs), nil
+}
+
+// rateOn returns the rate for converting from one currency to another that was in effect on a date,
+// falling back to the inverse of the opposite pair
+func (s *ExchangeRateService) rateOn(from, to string, date time.Time) (decimal.Decimal, time.Time, error) {
+	if from == to {
+		return decimal.NewFromInt(1), date, nil
+	}
+
+	day := date.Format(dateLayout)
+
+	var rate models.ExchangeRate
+	err := s.db.
+		Where("base_currency = ? AND quote_currency = ? AND effective_date <= ?", from, to, day).
+		Order("effective_date DESC").
+		First(&rate).Error
+	if err == nil {
+		return rate.Rate, rate.EffectiveDate, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return decimal.Zero, time.Time{}, fmt.Errorf("failed to fetch exchange rate: %w", err)
+	}
+
+	err = s.db.
+		Where("base_currency = ? AND quote_currency = ? AND effective_date <= ?", to, from, day).
+		Order("effective_date DESC").
+		First(&rate).Error
+	if err == nil {
+		return decimal.NewFromInt(1).Div(rate.Rate), rate.EffectiveDate, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return decimal.Zero, time.Time{}, fmt.Errorf("failed to fetch exchange rate: %w", err)
+	}
+
+	return decimal.Zero, time.Time{}, &MissingExchangeRateError{FromCurrency: from, ToCurrency: to, Date: day}
+}
+
+// convertBill returns a copy of the bill with its total in the given currency, converted at the
+// rate in effect on the bill date. Only the total changes: items, adjustments and payer amounts
+// are only ever used as weights for splitting it. The applied rate is nil when no conversion was needed.
+func (s *ExchangeRateService) convertBill(bill *models.Bill, currency string) (*models.Bill, *AppliedExchangeRate, error) {
+	if bill.Currency == "" || bill.Currency == currency {
+		return bill, nil, nil
+	}
+
+	rate, effectiveDate, err := s.rateOn(bill.Currency, currency, bill.BillDate)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	converted := *bill
+	converted.TotalAmount = bill.TotalAmount.Mul(rate).Round(minorUnitPlaces)
+	converted.Currency = currency
+
+	return &converted, &AppliedExchangeRate{
+		BillID:        bill.ID,
+		FromCurrency:  bill.Currency,
+		ToCurrency:    currency,
+		Rate:          rate,
+		EffectiveDate: effectiveDate.Format(dateLayout),
+	}, nil
+}
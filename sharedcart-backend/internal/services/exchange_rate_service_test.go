@@ -0,0 +1,223 @@
+package services
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/JacksonYuKe/sharedcart-backend/internal/models"
+	"github.com/shopspring/decimal"
+)
+
+func TestRateOn(t *testing.T) {
+	s := newTestServices(t)
+	_, err := s.rates.SetExchangeRates([]ExchangeRateRequest{
+		{BaseCurrency: "USD", QuoteCurrency: "EUR", Rate: decimal.RequireFromString("0.90"), EffectiveDate: "2024-01-01"},
+		{BaseCurrency: "USD", QuoteCurrency: "EUR", Rate: decimal.RequireFromString("0.92"), EffectiveDate: "2024-02-01"},
+		{BaseCurrency: "GBP", QuoteCurrency: "USD", Rate: decimal.RequireFromString("1.25"), EffectiveDate: "2024-01-01"},
+	})
+	if err != nil {
+		t.Fatalf("SetExchangeRates() error = %v", err)
+	}
+
+	// SQLite compares the stored dates as text, so every date falls after the rates' effective dates
+	tests := []struct {
+		name          string
+		from, to      string
+		date          string
+		wantRate      string
+		wantEffective string
+		wantMissing   bool
+	}{
+		{name: "Same currency", from: "USD", to: "USD", date: "2024-01-15", wantRate: "1", wantEffective: "2024-01-15"},
+		{name: "Rate in effect", from: "USD", to: "EUR", date: "2024-01-15", wantRate: "0.90", wantEffective: "2024-01-01"},
+		{name: "Latest rate before the date", from: "USD", to: "EUR", date: "2024-03-15", wantRate: "0.92", wantEffective: "2024-02-01"},
+		{name: "Inverse of the opposite pair", from: "USD", to: "GBP", date: "2024-01-15", wantRate: "0.8", wantEffective: "2024-01-01"},
+		{name: "Before the first rate", from: "USD", to: "EUR", date: "2023-12-31", wantMissing: true},
+		{name: "Unknown pair", from: "EUR", to: "JPY", date: "2024-01-15", wantMissing: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			date, _ := time.Parse(dateLayout, tt.date)
+			rate, effective, err := s.rates.rateOn(tt.from, tt.to, date)
+
+			if tt.wantMissing {
+				var missing *MissingExchangeRateError
+				if !errors.As(err, &missing) {
+					t.Fatalf("rateOn() error = %v, want a MissingExchangeRateError", err)
+				}
+				if missing.FromCurrency != tt.from || missing.ToCurrency != tt.to || missing.Date != tt.date {
+					t.Errorf("missing rate = %+v, want %s to %s on %s", missing, tt.from, tt.to, tt.date)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("rateOn() error = %v", err)
+			}
+			if !rate.Equal(decimal.RequireFromString(tt.wantRate)) {
+				t.Errorf("rateOn() rate = %s, want %s", rate, tt.wantRate)
+			}
+			if got := effective.Format(dateLayout); got != tt.wantEffective {
+				t.Errorf("rateOn() effective date = %s, want %s", got, tt.wantEffective)
+			}
+		})
+	}
+}
+
+func TestConvertBill(t *testing.T) {
+	s := newTestServices(t)
+	_, err := s.rates.SetExchangeRates([]ExchangeRateRequest{
+		{BaseCurrency: "USD", QuoteCurrency: "EUR", Rate: decimal.RequireFromString("0.90"), EffectiveDate: "2024-01-01"},
+	})
+	if err != nil {
+		t.Fatalf("SetExchangeRates() error = %v", err)
+	}
+	billDate := time.Date(2024, 1, 20, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		bill      models.Bill
+		currency  string
+		wantTotal string
+		wantRate  string // Empty when no conversion is needed
+		wantErr   bool
+	}{
+		{
+			name:      "Same currency",
+			bill:      models.Bill{ID: 1, TotalAmount: decimal.RequireFromString("12.34"), Currency: "USD", BillDate: billDate},
+			currency:  "USD",
+			wantTotal: "12.34",
+		},
+		{
+			name:      "Direct rate",
+			bill:      models.Bill{ID: 2, TotalAmount: decimal.RequireFromString("10.00"), Currency: "USD", BillDate: billDate},
+			currency:  "EUR",
+			wantTotal: "9.00",
+			wantRate:  "0.90",
+		},
+		{
+			name:      "Inverse rate rounded to the minor unit",
+			bill:      models.Bill{ID: 3, TotalAmount: decimal.RequireFromString("10.00"), Currency: "EUR", BillDate: billDate},
+			currency:  "USD",
+			wantTotal: "11.11",
+			wantRate:  "1.1111111111111111",
+		},
+		{
+			name:     "Missing rate",
+			bill:     models.Bill{ID: 4, TotalAmount: decimal.RequireFromString("10.00"), Currency: "GBP", BillDate: billDate},
+			currency: "USD",
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			originalTotal := tt.bill.TotalAmount
+			converted, applied, err := s.rates.convertBill(&tt.bill, tt.currency)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("convertBill() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !converted.TotalAmount.Equal(decimal.RequireFromString(tt.wantTotal)) || converted.Currency != tt.currency {
+				t.Errorf("convertBill() = %s %s, want %s %s", converted.TotalAmount, converted.Currency, tt.wantTotal, tt.currency)
+			}
+			if !tt.bill.TotalAmount.Equal(originalTotal) {
+				t.Errorf("convertBill() changed the original bill total to %s", tt.bill.TotalAmount)
+			}
+			if tt.wantRate == "" {
+				if applied != nil {
+					t.Errorf("convertBill() applied %+v, want no rate", applied)
+				}
+				return
+			}
+			if applied == nil || applied.BillID != tt.bill.ID || !applied.Rate.Equal(decimal.RequireFromString(tt.wantRate)) || applied.EffectiveDate != "2024-01-01" {
+				t.Errorf("convertBill() applied %+v, want rate %s from 2024-01-01 for bill %d", applied, tt.wantRate, tt.bill.ID)
+			}
+		})
+	}
+}
+
+func TestImportFile(t *testing.T) {
+	tests := []struct {
+		name      string
+		contents  string
+		wantCount int
+		wantErr   bool
+	}{
+		{
+			name:      "Header, lowercase codes and spaces",
+			contents:  "base_currency,quote_currency,rate,effective_date\nusd, eur, 0.90, 2024-01-01\nGBP,USD,1.25,2024-01-01\n",
+			wantCount: 2,
+		},
+		{
+			name:      "Without header",
+			contents:  "USD,EUR,0.90,2024-01-01\n",
+			wantCount: 1,
+		},
+		{
+			name:      "Empty file",
+			contents:  "",
+			wantCount: 0,
+		},
+		{
+			name:     "Invalid rate",
+			contents: "USD,EUR,abc,2024-01-01\n",
+			wantErr:  true,
+		},
+		{
+			name:     "Missing column",
+			contents: "USD,EUR,0.90\n",
+			wantErr:  true,
+		},
+		{
+			name:     "Invalid date",
+			contents: "USD,EUR,0.90,01/02/2024\n",
+			wantErr:  true,
+		},
+		{
+			name:     "Same currency on both sides",
+			contents: "USD,USD,1,2024-01-01\n",
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServices(t)
+			path := filepath.Join(t.TempDir(), "rates.csv")
+			if err := os.WriteFile(path, []byte(tt.contents), 0o600); err != nil {
+				t.Fatalf("failed to write rate file: %v", err)
+			}
+
+			count, err := s.rates.ImportFile(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ImportFile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if count != tt.wantCount {
+				t.Errorf("ImportFile() = %d, want %d", count, tt.wantCount)
+			}
+
+			rates, err := s.rates.GetExchangeRates("", "")
+			if err != nil {
+				t.Fatalf("GetExchangeRates() error = %v", err)
+			}
+			if len(rates) != tt.wantCount {
+				t.Errorf("stored %d rates, want %d", len(rates), tt.wantCount)
+			}
+			for _, rate := range rates {
+				if rate.BaseCurrency == "USD" && (rate.QuoteCurrency != "EUR" || !rate.Rate.Equal(decimal.RequireFromString("0.90"))) {
+					t.Errorf("stored rate %s/%s = %s, want USD/EUR 0.90", rate.BaseCurrency, rate.QuoteCurrency, rate.Rate)
+				}
+			}
+		})
+	}
+
+	if _, err := newTestServices(t).rates.ImportFile(filepath.Join(t.TempDir(), "missing.csv")); err == nil {
+		t.Error("ImportFile() of a missing file succeeded, want an error")
+	}
+}
@@ -1,23 +1,30 @@
 package services
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"slices"
+	"time"
 
 	"github.com/JacksonYuKe/sharedcart-backend/internal/database"
 	"github.com/JacksonYuKe/sharedcart-backend/internal/models"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // GroupService handles group-related operations
 type GroupService struct {
-	db *gorm.DB
+	db            *gorm.DB
+	ledgerService *LedgerService
 }
 
 // NewGroupService creates a new group service
-func NewGroupService() *GroupService {
+func NewGroupService(ledgerService *LedgerService) *GroupService {
 	return &GroupService{
-		db: database.DB,
+		db:            database.DB,
+		ledgerService: ledgerService,
 	}
 }
 
@@ -25,6 +32,7 @@ func NewGroupService() *GroupService {
 type CreateGroupRequest struct {
 	Name        string `json:"name" binding:"required,min=2,max=100"`
 	Description string `json:"description" binding:"max=500"`
+	Currency    string `json:"currency" binding:"omitempty,len=3,uppercase"` // Defaults to USD
 }
 
 // AddMemberRequest represents member addition input
@@ -32,6 +40,23 @@ type AddMemberRequest struct {
 	Email string `json:"email" binding:"required,email"`
 }
 
+// RemoveMemberRequest represents options for removing a member who still has a balance
+type RemoveMemberRequest struct {
+	Force      bool  `form:"force"`       // Remove the member even though their balance isn't zero
+	ReassignTo *uint `form:"reassign_to"` // With force, move the balance to this member instead of writing it off among everyone else
+}
+
+// LeaveGroupResult reports what happened to the group when a member left
+type LeaveGroupResult struct {
+	NewAdminID *uint `json:"new_admin_id,omitempty"` // Member who became admin because the last admin left
+	Archived   bool  `json:"archived"`               // Set when nobody is left in the group
+}
+
+// AddPlaceholderRequest represents placeholder member input
+type AddPlaceholderRequest struct {
+	Name string `json:"name" binding:"required,min=1,max=100"`
+}
+
 // UpdateMemberRoleRequest represents role update input
 type UpdateMemberRoleRequest struct {
 	Role string `json:"role" binding:"required,oneof=admin member"`
@@ -52,8 +77,12 @@ func (s *GroupService) CreateGroup(userID uint, req CreateGroupRequest) (*models
 		Name:        req.Name,
 		Description: req.Description,
 		CreatedByID: userID,
+		Currency:    req.Currency,
 		IsActive:    true,
 	}
+	if group.Currency == "" {
+		group.Currency = "USD"
+	}
 
 	if err := tx.Create(&group).Error; err != nil {
 		tx.Rollback()
@@ -91,7 +120,7 @@ func (s *GroupService) GetUserGroups(userID uint) ([]models.Group, error) {
 
 	err := s.db.
 		Joins("JOIN group_members ON group_members.group_id = groups.id").
-		Where("group_members.user_id = ? AND groups.deleted_at IS NULL AND groups.is_active = ?", userID, true).
+		Where("group_members.user_id = ? AND group_members.left_at IS NULL AND groups.deleted_at IS NULL AND groups.is_active = ?", userID, true).
 		Preload("CreatedBy").
 		Find(&groups).Error
 
@@ -103,7 +132,7 @@ func (s *GroupService) GetUserGroups(userID uint) ([]models.Group, error) {
 	for i := range groups {
 		var members []models.GroupMember
 		err := s.db.
-			Where("group_id = ?", groups[i].ID).
+			Where("group_id = ? AND left_at IS NULL", groups[i].ID).
 			Preload("User").
 			Find(&members).Error
 
@@ -140,7 +169,7 @@ func (s *GroupService) GetGroupByID(groupID, userID uint) (*models.Group, error)
 	// Load members with role information
 	var members []models.GroupMember
 	err = s.db.
-		Where("group_id = ?", groupID).
+		Where("group_id = ? AND left_at IS NULL", groupID).
 		Preload("User").
 		Find(&members).Error
 
@@ -165,6 +194,18 @@ func (s *GroupService) UpdateGroup(groupID, userID uint, req CreateGroupRequest)
 		return nil, errors.New("group not found")
 	}
 
+	// Balances are kept in the group currency, so it can't change once bills count towards them
+	if req.Currency != "" && req.Currency != group.Currency {
+		var finalizedBills int64
+		s.db.Model(&models.Bill{}).
+			Where("group_id = ? AND status IN ?", groupID, []string{"finalized", "settled"}).
+			Count(&finalizedBills)
+		if finalizedBills > 0 {
+			return nil, errors.New("cannot change currency after bills are finalized")
+		}
+		group.Currency = req.Currency
+	}
+
 	// Update fields
 	group.Name = req.Name
 	group.Description = req.Description
@@ -176,7 +217,56 @@ func (s *GroupService) UpdateGroup(groupID, userID uint, req CreateGroupRequest)
 	return &group, nil
 }
 
-// AddMember adds a new member to the group
+// AddPlaceholder adds a named participant who doesn't have an account. Placeholders pay for and
+// share bills like any other member until the person they stand for claims them through an invitation.
+func (s *GroupService) AddPlaceholder(groupID, userID uint, req AddPlaceholderRequest) (*models.GroupMember, error) {
+	if !s.IsUserMember(groupID, userID) {
+		return nil, errors.New("user is not a member of this group")
+	}
+
+	// Emails must be unique, so give the placeholder an address that can never receive mail
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, fmt.Errorf("failed to generate placeholder email: %w", err)
+	}
+
+	// Start transaction
+	tx := s.db.Begin()
+
+	user := models.User{
+		Email:         fmt.Sprintf("placeholder-%s@placeholder.invalid", hex.EncodeToString(suffix)),
+		Name:          req.Name,
+		IsActive:      true,
+		IsPlaceholder: true,
+	}
+	if err := tx.Create(&user).Error; err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("failed to create placeholder: %w", err)
+	}
+
+	member := models.GroupMember{
+		UserID:    user.ID,
+		GroupID:   groupID,
+		Role:      "member",
+		InvitedBy: userID,
+	}
+	if err := tx.Create(&member).Error; err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("failed to add member: %w", err)
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	member.User = &user
+	return &member, nil
+}
+
+// AddMember adds a new member to the group without asking them first.
+//
+// Deprecated: members should join by accepting an invitation. This stays for clients that still
+// add members directly.
 func (s *GroupService) AddMember(groupID, inviterID uint, req AddMemberRequest) error {
 	// Check if inviter is admin
 	if !s.IsUserAdmin(groupID, inviterID) {
@@ -185,7 +275,7 @@ func (s *GroupService) AddMember(groupID, inviterID uint, req AddMemberRequest)
 
 	// Find user by email
 	var user models.User
-	if err := s.db.Where("email = ?", req.Email).First(&user).Error; err != nil {
+	if err := s.db.Where("email = ? AND is_placeholder = ?", req.Email, false).First(&user).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return errors.New("user not found with this email")
 		}
@@ -212,8 +302,11 @@ func (s *GroupService) AddMember(groupID, inviterID uint, req AddMemberRequest)
 	return nil
 }
 
-// RemoveMember removes a member from the group
-func (s *GroupService) RemoveMember(groupID, userID, targetUserID uint) error {
+// RemoveMember removes a member from the group. The membership is kept with the time the member
+// left, so bills from before then still include them. A member who owes or is owed money can only
+// be removed by force, which either writes their balance off among the remaining members or
+// reassigns it to one of them.
+func (s *GroupService) RemoveMember(groupID, userID, targetUserID uint, req RemoveMemberRequest) error {
 	// Check if user is admin
 	if !s.IsUserAdmin(groupID, userID) {
 		return errors.New("only group admins can remove members")
@@ -227,22 +320,268 @@ func (s *GroupService) RemoveMember(groupID, userID, targetUserID uint) error {
 		}
 	}
 
-	// Remove member
-	result := s.db.
-		Where("group_id = ? AND user_id = ?", groupID, targetUserID).
-		Delete(&models.GroupMember{})
+	if req.ReassignTo != nil && !req.Force {
+		return errors.New("reassign_to requires force")
+	}
 
-	if result.Error != nil {
-		return fmt.Errorf("failed to remove member: %w", result.Error)
+	// Start transaction
+	tx := s.db.Begin()
+
+	member, err := s.activeMembership(tx, groupID, targetUserID)
+	if err != nil {
+		tx.Rollback()
+		return err
 	}
 
-	if result.RowsAffected == 0 {
-		return errors.New("member not found in group")
+	if err := s.checkNoOpenTransactions(tx, groupID, targetUserID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := s.checkNotOnPendingBills(tx, groupID, targetUserID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	balance, err := s.ledgerService.balanceOf(tx, groupID, targetUserID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if !balance.IsZero() {
+		if !req.Force {
+			tx.Rollback()
+			return errors.New("member has an outstanding balance")
+		}
+
+		recipients, err := s.balanceRecipients(tx, groupID, targetUserID, req.ReassignTo)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := s.ledgerService.transferBalance(tx, groupID, targetUserID, balance, recipients); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if err := tx.Model(member).Update("left_at", time.Now()).Error; err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to remove member: %w", err)
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// LeaveGroup lets a member leave a group once their balance is zero. When the last admin leaves,
+// the member who joined earliest becomes admin, and a group left without members is archived.
+func (s *GroupService) LeaveGroup(groupID, userID uint) (*LeaveGroupResult, error) {
+	// Start transaction
+	tx := s.db.Begin()
+
+	member, err := s.activeMembership(tx, groupID, userID)
+	if err != nil {
+		tx.Rollback()
+		if err.Error() == "member not found in group" {
+			return nil, errors.New("user is not a member of this group")
+		}
+		return nil, err
+	}
+
+	if err := s.checkNoOpenTransactions(tx, groupID, userID); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := s.checkNotOnPendingBills(tx, groupID, userID); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	balance, err := s.ledgerService.balanceOf(tx, groupID, userID)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if !balance.IsZero() {
+		tx.Rollback()
+		return nil, errors.New("member has an outstanding balance")
+	}
+
+	if err := tx.Model(member).Update("left_at", time.Now()).Error; err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("failed to leave group: %w", err)
+	}
+
+	// Placeholders can't run a group, so only people with accounts count
+	var remaining []models.GroupMember
+	err = tx.
+		Clauses(clause.Locking{Strength: "UPDATE", Table: clause.Table{Name: "group_members"}}).
+		Joins("JOIN users ON users.id = group_members.user_id AND users.is_placeholder = ?", false).
+		Where("group_members.group_id = ? AND group_members.left_at IS NULL", groupID).
+		Order("group_members.joined_at, group_members.id").
+		Find(&remaining).Error
+
+	if err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("failed to get group members: %w", err)
+	}
+
+	result := &LeaveGroupResult{}
+	switch {
+	case len(remaining) == 0:
+		// Nobody with an account is left, so archive the group rather than deleting its history
+		if err := tx.Model(&models.Group{}).Where("id = ?", groupID).Update("is_active", false).Error; err != nil {
+			tx.Rollback()
+			return nil, fmt.Errorf("failed to archive group: %w", err)
+		}
+		result.Archived = true
+
+	case member.Role == "admin" && !slices.ContainsFunc(remaining, func(m models.GroupMember) bool { return m.Role == "admin" }):
+		// Hand the group over to the longest-standing member
+		successor := remaining[0]
+		if err := tx.Model(&successor).Update("role", "admin").Error; err != nil {
+			tx.Rollback()
+			return nil, fmt.Errorf("failed to hand over admin role: %w", err)
+		}
+		result.NewAdminID = &successor.UserID
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return result, nil
+}
+
+// activeMembership returns a user's current membership of a group, locking it until the transaction ends
+func (s *GroupService) activeMembership(tx *gorm.DB, groupID, userID uint) (*models.GroupMember, error) {
+	var member models.GroupMember
+	err := tx.
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("group_id = ? AND user_id = ? AND left_at IS NULL", groupID, userID).
+		First(&member).Error
+
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("member not found in group")
+		}
+		return nil, fmt.Errorf("failed to get member: %w", err)
+	}
+
+	return &member, nil
+}
+
+// checkNoOpenTransactions refuses while a member still has to pay or receive money in a pending
+// or confirmed settlement; those settlements need to be paid off, cancelled or voided first
+func (s *GroupService) checkNoOpenTransactions(tx *gorm.DB, groupID, userID uint) error {
+	var count int64
+	err := tx.Model(&models.SettlementTransaction{}).
+		Joins("JOIN settlements ON settlements.id = settlement_transactions.settlement_id").
+		Where("settlements.group_id = ? AND settlements.status IN ? AND settlements.deleted_at IS NULL", groupID, []string{"pending", "confirmed"}).
+		Where("settlement_transactions.status IN ?", []string{"pending", "partially_paid"}).
+		Where("settlement_transactions.from_user_id = ? OR settlement_transactions.to_user_id = ?", userID, userID).
+		Count(&count).Error
+
+	if err != nil {
+		return fmt.Errorf("failed to check settlement transactions: %w", err)
+	}
+
+	if count > 0 {
+		return errors.New("member has unpaid settlement transactions")
+	}
+
+	return nil
+}
+
+// checkNotOnPendingBills refuses while a member pays for or shares in bills that aren't finalized
+// yet, since finalizing them later would give the member a balance after they are gone
+func (s *GroupService) checkNotOnPendingBills(tx *gorm.DB, groupID, userID uint) error {
+	var bills []models.Bill
+	err := tx.
+		Where("group_id = ? AND status = ?", groupID, "pending").
+		Preload("Payers").
+		Preload("Adjustments").
+		Preload("Items.ItemOwners").
+		Find(&bills).Error
+
+	if err != nil {
+		return fmt.Errorf("failed to check pending bills: %w", err)
+	}
+	if len(bills) == 0 {
+		return nil
+	}
+
+	members, err := s.memberHistory(groupID)
+	if err != nil {
+		return err
+	}
+
+	for i := range bills {
+		if billInvolves(&bills[i], members, userID) {
+			return errors.New("member is on bills that are not finalized")
+		}
 	}
 
 	return nil
 }
 
+// billInvolves checks if a user pays for a bill or would share in it once it is finalized
+func billInvolves(bill *models.Bill, members []models.GroupMember, userID uint) bool {
+	if bill.PaidByID == userID {
+		return true
+	}
+	for _, payer := range bill.Payers {
+		if payer.UserID == userID {
+			return true
+		}
+	}
+	for _, share := range billOwesShares(bill, members) {
+		if share.userID == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// balanceRecipients returns who takes on a departing member's balance: the member it is reassigned
+// to, or otherwise everyone else in the group
+func (s *GroupService) balanceRecipients(tx *gorm.DB, groupID, departingUserID uint, reassignTo *uint) ([]uint, error) {
+	if reassignTo != nil {
+		if *reassignTo == departingUserID {
+			return nil, errors.New("reassign target must be another member of the group")
+		}
+		if _, err := s.activeMembership(tx, groupID, *reassignTo); err != nil {
+			if err.Error() == "member not found in group" {
+				return nil, errors.New("reassign target must be another member of the group")
+			}
+			return nil, err
+		}
+		return []uint{*reassignTo}, nil
+	}
+
+	var userIDs []uint
+	err := tx.Model(&models.GroupMember{}).
+		Where("group_id = ? AND user_id <> ? AND left_at IS NULL", groupID, departingUserID).
+		Distinct().
+		Pluck("user_id", &userIDs).Error
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to get group members: %w", err)
+	}
+
+	if len(userIDs) == 0 {
+		return nil, errors.New("no members left to take on the balance")
+	}
+
+	return userIDs, nil
+}
+
 // UpdateMemberRole updates a member's role in the group
 func (s *GroupService) UpdateMemberRole(groupID, userID, targetUserID uint, req UpdateMemberRoleRequest) error {
 	// Check if user is admin
@@ -258,9 +597,17 @@ func (s *GroupService) UpdateMemberRole(groupID, userID, targetUserID uint, req
 		}
 	}
 
+	// Placeholders can't log in, so they can't run the group
+	if req.Role == "admin" {
+		var target models.User
+		if err := s.db.Select("id", "is_placeholder").First(&target, targetUserID).Error; err == nil && target.IsPlaceholder {
+			return errors.New("placeholders cannot be admins")
+		}
+	}
+
 	// Update role
 	result := s.db.Model(&models.GroupMember{}).
-		Where("group_id = ? AND user_id = ?", groupID, targetUserID).
+		Where("group_id = ? AND user_id = ? AND left_at IS NULL", groupID, targetUserID).
 		Update("role", req.Role)
 
 	if result.Error != nil {
@@ -281,10 +628,26 @@ func (s *GroupService) GetGroupMembers(groupID, userID uint) ([]models.GroupMemb
 		return nil, errors.New("user is not a member of this group")
 	}
 
+	var members []models.GroupMember
+	err := s.db.
+		Where("group_id = ? AND left_at IS NULL", groupID).
+		Preload("User").
+		Find(&members).Error
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to get group members: %w", err)
+	}
+
+	return members, nil
+}
+
+// memberHistory retrieves every membership a group has had, including members who have since left
+func (s *GroupService) memberHistory(groupID uint) ([]models.GroupMember, error) {
 	var members []models.GroupMember
 	err := s.db.
 		Where("group_id = ?", groupID).
 		Preload("User").
+		Order("joined_at, id").
 		Find(&members).Error
 
 	if err != nil {
@@ -300,6 +663,15 @@ func (s *GroupService) GetGroupMembers(groupID, userID uint) ([]models.GroupMemb
 func (s *GroupService) IsUserMember(groupID, userID uint) bool {
 	var count int64
 	s.db.Model(&models.GroupMember{}).
+		Where("group_id = ? AND user_id = ? AND left_at IS NULL", groupID, userID).
+		Count(&count)
+	return count > 0
+}
+
+// hasBeenMember checks if a user has ever belonged to a group, including memberships that ended
+func (s *GroupService) hasBeenMember(db *gorm.DB, groupID, userID uint) bool {
+	var count int64
+	db.Model(&models.GroupMember{}).
 		Where("group_id = ? AND user_id = ?", groupID, userID).
 		Count(&count)
 	return count > 0
@@ -309,7 +681,7 @@ func (s *GroupService) IsUserMember(groupID, userID uint) bool {
 func (s *GroupService) IsUserAdmin(groupID, userID uint) bool {
 	var member models.GroupMember
 	err := s.db.
-		Where("group_id = ? AND user_id = ? AND role = ?", groupID, userID, "admin").
+		Where("group_id = ? AND user_id = ? AND role = ? AND left_at IS NULL", groupID, userID, "admin").
 		First(&member).Error
 	return err == nil
 }
@@ -318,7 +690,7 @@ func (s *GroupService) IsUserAdmin(groupID, userID uint) bool {
 func (s *GroupService) CountGroupAdmins(groupID uint) int64 {
 	var count int64
 	s.db.Model(&models.GroupMember{}).
-		Where("group_id = ? AND role = ?", groupID, "admin").
+		Where("group_id = ? AND role = ? AND left_at IS NULL", groupID, "admin").
 		Count(&count)
 	return count
 }
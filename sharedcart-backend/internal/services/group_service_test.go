@@ -0,0 +1,166 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/JacksonYuKe/sharedcart-backend/internal/models"
+	"github.com/shopspring/decimal"
+)
+
+func TestBillInvolves(t *testing.T) {
+	march := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	members := []models.GroupMember{
+		{UserID: 1, JoinedAt: march},
+		{UserID: 2, JoinedAt: march},
+		{UserID: 3, JoinedAt: march},
+		// Joined after the bills below were dated
+		{UserID: 4, JoinedAt: march.AddDate(0, 1, 0)},
+	}
+	billDate := march.AddDate(0, 0, 10)
+
+	sharedBill := models.Bill{
+		PaidByID: 1,
+		BillDate: billDate,
+		Items: []models.BillItem{
+			{Amount: decimal.RequireFromString("12.00"), Quantity: decimal.NewFromInt(1), IsShared: true},
+		},
+	}
+	personalBill := models.Bill{
+		PaidByID: 1,
+		BillDate: billDate,
+		Payers:   []models.BillPayer{{UserID: 1}, {UserID: 2}},
+		Items: []models.BillItem{
+			{Amount: decimal.RequireFromString("8.00"), Quantity: decimal.NewFromInt(1), ItemOwners: []models.ItemOwner{{UserID: 1}}},
+		},
+	}
+
+	tests := []struct {
+		name   string
+		bill   *models.Bill
+		userID uint
+		want   bool
+	}{
+		{"payer", &sharedBill, 1, true},
+		{"shares a shared item", &sharedBill, 3, true},
+		{"joined after the bill date", &sharedBill, 4, false},
+		{"one of several payers", &personalBill, 2, true},
+		{"item owner", &personalBill, 1, true},
+		{"not on the bill", &personalBill, 3, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := billInvolves(tt.bill, members, tt.userID); got != tt.want {
+				t.Errorf("billInvolves(user %d) = %v, want %v", tt.userID, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLeaveGroup(t *testing.T) {
+	t.Run("Admin hands over to the earliest member", func(t *testing.T) {
+		s := newTestServices(t)
+		alice := s.createUser(t, "alice")
+		bob := s.createUser(t, "bob")
+		carol := s.createUser(t, "carol")
+		group := s.createGroup(t, alice)
+		s.addMember(t, group.ID, bob, "member", time.Now().AddDate(0, 0, -10))
+		s.addMember(t, group.ID, carol, "member", time.Now().AddDate(0, 0, -20))
+
+		result, err := s.group.LeaveGroup(group.ID, alice.ID)
+		if err != nil {
+			t.Fatalf("LeaveGroup() error = %v", err)
+		}
+		if result.Archived || result.NewAdminID == nil || *result.NewAdminID != carol.ID {
+			t.Fatalf("LeaveGroup() = %+v, want carol as the new admin", result)
+		}
+
+		if !s.group.IsUserAdmin(group.ID, carol.ID) {
+			t.Error("carol is not an admin")
+		}
+		if s.group.IsUserAdmin(group.ID, bob.ID) {
+			t.Error("bob became an admin as well")
+		}
+		if s.group.IsUserMember(group.ID, alice.ID) {
+			t.Error("alice is still a member after leaving")
+		}
+	})
+
+	t.Run("Archived when only placeholders remain", func(t *testing.T) {
+		s := newTestServices(t)
+		alice := s.createUser(t, "alice")
+		grandma := s.createPlaceholder(t, "grandma")
+		group := s.createGroup(t, alice, grandma)
+
+		result, err := s.group.LeaveGroup(group.ID, alice.ID)
+		if err != nil {
+			t.Fatalf("LeaveGroup() error = %v", err)
+		}
+		if !result.Archived || result.NewAdminID != nil {
+			t.Errorf("LeaveGroup() = %+v, want the group archived without a new admin", result)
+		}
+
+		var stored models.Group
+		if err := s.db.First(&stored, group.ID).Error; err != nil {
+			t.Fatalf("failed to reload group: %v", err)
+		}
+		if stored.IsActive {
+			t.Error("group is still active")
+		}
+	})
+
+	refusals := []struct {
+		name    string
+		setup   func(t *testing.T, s *testServices, group models.Group, alice, bob models.User)
+		wantErr string
+	}{
+		{
+			name: "Outstanding balance",
+			setup: func(t *testing.T, s *testServices, group models.Group, alice, bob models.User) {
+				s.createFinalizedBill(t, group.ID, alice, "20.00")
+			},
+			wantErr: "member has an outstanding balance",
+		},
+		{
+			name: "Bills that are not finalized",
+			setup: func(t *testing.T, s *testServices, group models.Group, alice, bob models.User) {
+				_, err := s.bill.CreateBill(alice.ID, CreateBillRequest{
+					GroupID:     group.ID,
+					Title:       "Groceries",
+					TotalAmount: decimal.RequireFromString("20.00"),
+					Items:       []CreateBillItemRequest{{Name: "Groceries", Amount: decimal.RequireFromString("20.00"), IsShared: true}},
+				})
+				if err != nil {
+					t.Fatalf("failed to create bill: %v", err)
+				}
+			},
+			wantErr: "member is on bills that are not finalized",
+		},
+		{
+			name: "Unpaid settlement transactions",
+			setup: func(t *testing.T, s *testServices, group models.Group, alice, bob models.User) {
+				bill := s.createFinalizedBill(t, group.ID, alice, "20.00")
+				s.createSettlement(t, group.ID, alice, bill)
+			},
+			wantErr: "member has unpaid settlement transactions",
+		},
+	}
+
+	for _, tt := range refusals {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServices(t)
+			alice := s.createUser(t, "alice")
+			bob := s.createUser(t, "bob")
+			group := s.createGroup(t, alice, bob)
+			tt.setup(t, s, group, alice, bob)
+
+			if _, err := s.group.LeaveGroup(group.ID, bob.ID); err == nil || err.Error() != tt.wantErr {
+				t.Fatalf("LeaveGroup() error = %v, want %s", err, tt.wantErr)
+			}
+			if !s.group.IsUserMember(group.ID, bob.ID) {
+				t.Error("bob left the group despite the refusal")
+			}
+		})
+	}
+}
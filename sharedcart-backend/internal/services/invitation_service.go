@@ -0,0 +1,364 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/JacksonYuKe/sharedcart-backend/config"
+	"github.com/JacksonYuKe/sharedcart-backend/internal/database"
+	"github.com/JacksonYuKe/sharedcart-backend/internal/models"
+	"github.com/JacksonYuKe/sharedcart-backend/pkg/utils"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// InvitationService handles inviting people to groups. Invitations are addressed by email and
+// only add someone to a group once they accept.
+type InvitationService struct {
+	db           *gorm.DB
+	config       *config.JWTConfig
+	groupService *GroupService
+}
+
+// NewInvitationService creates a new invitation service
+func NewInvitationService(cfg *config.JWTConfig, groupService *GroupService) *InvitationService {
+	return &InvitationService{
+		db:           database.DB,
+		config:       cfg,
+		groupService: groupService,
+	}
+}
+
+// CreateInvitationRequest represents invitation input
+type CreateInvitationRequest struct {
+	Email         string `json:"email" binding:"required,email"`
+	PlaceholderID *uint  `json:"placeholder_id"` // Placeholder member the invitee should take over
+}
+
+// RespondInvitationRequest represents accepting or declining an invitation
+type RespondInvitationRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// InvitationWithToken is an invitation together with the token that answers it
+type InvitationWithToken struct {
+	Invitation models.GroupInvitation `json:"invitation"`
+	Token      string                 `json:"token"`
+}
+
+// CreateInvitation invites an email address to a group. Inviting an address that already has a
+// pending invitation sends it again with a fresh expiry. An invitation can name a placeholder
+// member, whose history the invitee takes over when they accept.
+func (s *InvitationService) CreateInvitation(groupID, inviterID uint, req CreateInvitationRequest) (*InvitationWithToken, error) {
+	// Check if inviter is admin
+	if !s.groupService.IsUserAdmin(groupID, inviterID) {
+		return nil, errors.New("only group admins can invite members")
+	}
+
+	email := strings.ToLower(strings.TrimSpace(req.Email))
+
+	if req.PlaceholderID != nil && !s.isPlaceholderMember(s.db, groupID, *req.PlaceholderID) {
+		return nil, errors.New("placeholder not found in group")
+	}
+
+	// The invitee may not have an account yet
+	var inviteeID *uint
+	var user models.User
+	err := s.db.Where("email = ?", email).First(&user).Error
+	if err == nil {
+		if s.groupService.IsUserMember(groupID, user.ID) {
+			return nil, errors.New("user is already a member of this group")
+		}
+		// Merging a placeholder into someone with their own history here would mix the two up
+		if req.PlaceholderID != nil && s.groupService.hasBeenMember(s.db, groupID, user.ID) {
+			return nil, errors.New("user has already been a member of this group")
+		}
+		inviteeID = &user.ID
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("failed to find user: %w", err)
+	}
+
+	expiresAt := time.Now().Add(time.Hour * time.Duration(s.config.InviteExpiryHours))
+
+	var invitation models.GroupInvitation
+	err = s.db.
+		Where("group_id = ? AND email = ? AND status = ?", groupID, email, "pending").
+		First(&invitation).Error
+
+	switch {
+	case err == nil:
+		invitation.ExpiresAt = expiresAt
+		invitation.InvitedByID = inviterID
+		invitation.InviteeID = inviteeID
+		invitation.PlaceholderID = req.PlaceholderID
+		if err := s.db.Save(&invitation).Error; err != nil {
+			return nil, fmt.Errorf("failed to resend invitation: %w", err)
+		}
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		invitation = models.GroupInvitation{
+			GroupID:       groupID,
+			Email:         email,
+			InviteeID:     inviteeID,
+			InvitedByID:   inviterID,
+			PlaceholderID: req.PlaceholderID,
+			Status:        "pending",
+			ExpiresAt:     expiresAt,
+		}
+		if err := s.db.Create(&invitation).Error; err != nil {
+			return nil, fmt.Errorf("failed to create invitation: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("failed to check invitations: %w", err)
+	}
+
+	token, err := utils.GenerateInviteToken(invitation.ID, groupID, email, s.config.Secret, expiresAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate token: %w", err)
+	}
+
+	return &InvitationWithToken{Invitation: invitation, Token: token}, nil
+}
+
+// GetGroupInvitations lists a group's invitations, optionally filtered by status
+func (s *InvitationService) GetGroupInvitations(groupID, userID uint, status string) ([]models.GroupInvitation, error) {
+	if !s.groupService.IsUserAdmin(groupID, userID) {
+		return nil, errors.New("only group admins can view invitations")
+	}
+
+	query := s.db.Where("group_id = ?", groupID)
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var invitations []models.GroupInvitation
+	err := query.
+		Order("created_at DESC").
+		Preload("Invitee").
+		Preload("InvitedBy").
+		Find(&invitations).Error
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to get invitations: %w", err)
+	}
+
+	return invitations, nil
+}
+
+// RevokeInvitation withdraws a pending invitation
+func (s *InvitationService) RevokeInvitation(groupID, invitationID, userID uint) error {
+	if !s.groupService.IsUserAdmin(groupID, userID) {
+		return errors.New("only group admins can revoke invitations")
+	}
+
+	now := time.Now()
+	result := s.db.Model(&models.GroupInvitation{}).
+		Where("id = ? AND group_id = ? AND status = ?", invitationID, groupID, "pending").
+		Updates(map[string]interface{}{"status": "revoked", "responded_at": now})
+
+	if result.Error != nil {
+		return fmt.Errorf("failed to revoke invitation: %w", result.Error)
+	}
+
+	if result.RowsAffected == 0 {
+		var count int64
+		s.db.Model(&models.GroupInvitation{}).Where("id = ? AND group_id = ?", invitationID, groupID).Count(&count)
+		if count == 0 {
+			return errors.New("invitation not found")
+		}
+		return errors.New("invitation is no longer pending")
+	}
+
+	return nil
+}
+
+// GetUserInvitations lists the pending invitations addressed to a user, with the tokens to answer them
+func (s *InvitationService) GetUserInvitations(userID uint) ([]InvitationWithToken, error) {
+	var user models.User
+	if err := s.db.First(&user, userID).Error; err != nil {
+		return nil, fmt.Errorf("failed to find user: %w", err)
+	}
+
+	var invitations []models.GroupInvitation
+	err := s.db.
+		Where("(invitee_id = ? OR email = ?) AND status = ? AND expires_at > ?", userID, strings.ToLower(user.Email), "pending", time.Now()).
+		Order("created_at DESC").
+		Preload("Group").
+		Preload("InvitedBy").
+		Find(&invitations).Error
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to get invitations: %w", err)
+	}
+
+	results := make([]InvitationWithToken, 0, len(invitations))
+	for _, invitation := range invitations {
+		token, err := utils.GenerateInviteToken(invitation.ID, invitation.GroupID, invitation.Email, s.config.Secret, invitation.ExpiresAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate token: %w", err)
+		}
+		results = append(results, InvitationWithToken{Invitation: invitation, Token: token})
+	}
+
+	return results, nil
+}
+
+// AcceptInvitation adds the user to the group they were invited to. If the invitation names a
+// placeholder, the user takes over the placeholder's membership and history instead.
+func (s *InvitationService) AcceptInvitation(userID uint, req RespondInvitationRequest) (*models.GroupInvitation, error) {
+	return s.respond(userID, req.Token, true)
+}
+
+// DeclineInvitation turns an invitation down
+func (s *InvitationService) DeclineInvitation(userID uint, req RespondInvitationRequest) (*models.GroupInvitation, error) {
+	return s.respond(userID, req.Token, false)
+}
+
+// respond records the user's answer to the invitation a token belongs to
+func (s *InvitationService) respond(userID uint, token string, accept bool) (*models.GroupInvitation, error) {
+	claims, err := utils.ValidateInviteToken(token, s.config.Secret)
+	if err != nil {
+		return nil, errors.New("invalid or expired invitation token")
+	}
+
+	var user models.User
+	if err := s.db.First(&user, userID).Error; err != nil {
+		return nil, fmt.Errorf("failed to find user: %w", err)
+	}
+
+	// Start transaction
+	tx := s.db.Begin()
+
+	var invitation models.GroupInvitation
+	err = tx.
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		First(&invitation, claims.InvitationID).Error
+	if err != nil {
+		tx.Rollback()
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("invitation not found")
+		}
+		return nil, fmt.Errorf("failed to get invitation: %w", err)
+	}
+
+	if invitation.Email != strings.ToLower(user.Email) {
+		tx.Rollback()
+		return nil, errors.New("invitation was sent to a different email")
+	}
+	if invitation.Status != "pending" {
+		tx.Rollback()
+		return nil, errors.New("invitation is no longer pending")
+	}
+	if time.Now().After(invitation.ExpiresAt) {
+		tx.Rollback()
+		return nil, errors.New("invalid or expired invitation token")
+	}
+
+	invitation.Status = "declined"
+	if accept {
+		var group models.Group
+		if err := tx.Where("is_active = ?", true).First(&group, invitation.GroupID).Error; err != nil {
+			tx.Rollback()
+			return nil, errors.New("group not found")
+		}
+
+		// Someone may have joined through another invitation in the meantime
+		var memberships int64
+		tx.Model(&models.GroupMember{}).
+			Where("group_id = ? AND user_id = ? AND left_at IS NULL", invitation.GroupID, userID).
+			Count(&memberships)
+
+		if invitation.PlaceholderID != nil {
+			if s.groupService.hasBeenMember(tx, invitation.GroupID, userID) {
+				tx.Rollback()
+				return nil, errors.New("user has already been a member of this group")
+			}
+			if !s.isPlaceholderMember(tx, invitation.GroupID, *invitation.PlaceholderID) {
+				tx.Rollback()
+				return nil, errors.New("placeholder not found in group")
+			}
+			if err := claimPlaceholder(tx, *invitation.PlaceholderID, userID); err != nil {
+				tx.Rollback()
+				return nil, err
+			}
+		} else if memberships == 0 {
+			member := models.GroupMember{
+				UserID:    userID,
+				GroupID:   invitation.GroupID,
+				Role:      "member",
+				InvitedBy: invitation.InvitedByID,
+			}
+			if err := tx.Create(&member).Error; err != nil {
+				tx.Rollback()
+				return nil, fmt.Errorf("failed to add member: %w", err)
+			}
+		}
+		invitation.Status = "accepted"
+	}
+
+	now := time.Now()
+	invitation.InviteeID = &userID
+	invitation.RespondedAt = &now
+	if err := tx.Save(&invitation).Error; err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("failed to update invitation: %w", err)
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return &invitation, nil
+}
+
+// isPlaceholderMember checks if a user is a placeholder that currently belongs to a group
+func (s *InvitationService) isPlaceholderMember(db *gorm.DB, groupID, userID uint) bool {
+	var count int64
+	db.Model(&models.GroupMember{}).
+		Joins("JOIN users ON users.id = group_members.user_id").
+		Where("group_members.group_id = ? AND group_members.user_id = ? AND group_members.left_at IS NULL", groupID, userID).
+		Where("users.is_placeholder = ?", true).
+		Count(&count)
+	return count > 0
+}
+
+// placeholderReferences lists every column that refers to a user, so claiming a placeholder
+// moves all of its history over to the real account
+var placeholderReferences = []struct {
+	model  interface{}
+	column string
+}{
+	{&models.GroupMember{}, "user_id"},
+	{&models.Bill{}, "paid_by_id"},
+	{&models.Bill{}, "created_by_id"},
+	{&models.BillPayer{}, "user_id"},
+	{&models.ItemOwner{}, "user_id"},
+	{&models.SettlementTransaction{}, "from_user_id"},
+	{&models.SettlementTransaction{}, "to_user_id"},
+	{&models.SettlementBalance{}, "user_id"},
+	{&models.GroupBalance{}, "user_id"},
+	{&models.PaymentPreference{}, "user_id"},
+	{&models.PaymentPreference{}, "target_user_id"},
+	{&models.DebtOffset{}, "proposer_id"},
+	{&models.DebtOffset{}, "counterparty_id"},
+}
+
+// claimPlaceholder merges a placeholder into a real user. Everything the placeholder took part in
+// is reassigned, then the placeholder account is removed.
+func claimPlaceholder(tx *gorm.DB, placeholderID, userID uint) error {
+	for _, ref := range placeholderReferences {
+		err := tx.Unscoped().Model(ref.model).
+			Where(ref.column+" = ?", placeholderID).
+			Update(ref.column, userID).Error
+		if err != nil {
+			return fmt.Errorf("failed to claim placeholder: %w", err)
+		}
+	}
+
+	if err := tx.Delete(&models.User{}, placeholderID).Error; err != nil {
+		return fmt.Errorf("failed to remove placeholder: %w", err)
+	}
+
+	return nil
+}
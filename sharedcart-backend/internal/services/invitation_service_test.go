@@ -0,0 +1,299 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/JacksonYuKe/sharedcart-backend/internal/models"
+	"github.com/JacksonYuKe/sharedcart-backend/pkg/utils"
+	"github.com/shopspring/decimal"
+)
+
+// invite has the admin invite an email address to a group
+func (s *testServices) invite(t *testing.T, groupID uint, admin models.User, email string) *InvitationWithToken {
+	t.Helper()
+	invitation, err := s.invitation.CreateInvitation(groupID, admin.ID, CreateInvitationRequest{Email: email})
+	if err != nil {
+		t.Fatalf("CreateInvitation() error = %v", err)
+	}
+	return invitation
+}
+
+func TestRespondInvitation(t *testing.T) {
+	t.Run("Accept adds the member once", func(t *testing.T) {
+		s := newTestServices(t)
+		alice := s.createUser(t, "alice")
+		bob := s.createUser(t, "bob")
+		group := s.createGroup(t, alice)
+		invitation := s.invite(t, group.ID, alice, "Bob@Example.com")
+
+		accepted, err := s.invitation.AcceptInvitation(bob.ID, RespondInvitationRequest{Token: invitation.Token})
+		if err != nil {
+			t.Fatalf("AcceptInvitation() error = %v", err)
+		}
+		if accepted.Status != "accepted" || accepted.InviteeID == nil || *accepted.InviteeID != bob.ID {
+			t.Errorf("AcceptInvitation() = %+v, want accepted by bob", accepted)
+		}
+		if !s.group.IsUserMember(group.ID, bob.ID) {
+			t.Error("bob is not a member after accepting")
+		}
+
+		if _, err := s.invitation.AcceptInvitation(bob.ID, RespondInvitationRequest{Token: invitation.Token}); err == nil || err.Error() != "invitation is no longer pending" {
+			t.Errorf("repeated AcceptInvitation() error = %v, want invitation is no longer pending", err)
+		}
+		if _, err := s.invitation.DeclineInvitation(bob.ID, RespondInvitationRequest{Token: invitation.Token}); err == nil || err.Error() != "invitation is no longer pending" {
+			t.Errorf("DeclineInvitation() after accepting error = %v, want invitation is no longer pending", err)
+		}
+
+		var memberships int64
+		s.db.Model(&models.GroupMember{}).Where("group_id = ? AND user_id = ?", group.ID, bob.ID).Count(&memberships)
+		if memberships != 1 {
+			t.Errorf("bob has %d memberships, want 1", memberships)
+		}
+	})
+
+	t.Run("Decline leaves the group alone", func(t *testing.T) {
+		s := newTestServices(t)
+		alice := s.createUser(t, "alice")
+		bob := s.createUser(t, "bob")
+		group := s.createGroup(t, alice)
+		invitation := s.invite(t, group.ID, alice, "bob@example.com")
+
+		declined, err := s.invitation.DeclineInvitation(bob.ID, RespondInvitationRequest{Token: invitation.Token})
+		if err != nil {
+			t.Fatalf("DeclineInvitation() error = %v", err)
+		}
+		if declined.Status != "declined" || declined.RespondedAt == nil {
+			t.Errorf("DeclineInvitation() = %+v, want declined", declined)
+		}
+		if s.group.IsUserMember(group.ID, bob.ID) {
+			t.Error("bob became a member by declining")
+		}
+
+		if _, err := s.invitation.DeclineInvitation(bob.ID, RespondInvitationRequest{Token: invitation.Token}); err == nil || err.Error() != "invitation is no longer pending" {
+			t.Errorf("repeated DeclineInvitation() error = %v, want invitation is no longer pending", err)
+		}
+		if _, err := s.invitation.AcceptInvitation(bob.ID, RespondInvitationRequest{Token: invitation.Token}); err == nil || err.Error() != "invitation is no longer pending" {
+			t.Errorf("AcceptInvitation() after declining error = %v, want invitation is no longer pending", err)
+		}
+	})
+
+	refusals := []struct {
+		name    string
+		token   func(t *testing.T, s *testServices, invitation *InvitationWithToken) string
+		wantErr string
+	}{
+		{
+			name: "Sent to a different email",
+			token: func(t *testing.T, s *testServices, invitation *InvitationWithToken) string {
+				// Carol's token is valid, but bob is the one answering it
+				return s.invite(t, invitation.Invitation.GroupID, models.User{ID: invitation.Invitation.InvitedByID}, "carol@example.com").Token
+			},
+			wantErr: "invitation was sent to a different email",
+		},
+		{
+			name: "Expired token",
+			token: func(t *testing.T, s *testServices, invitation *InvitationWithToken) string {
+				token, err := utils.GenerateInviteToken(invitation.Invitation.ID, invitation.Invitation.GroupID, invitation.Invitation.Email, "test-secret", time.Now().Add(-time.Hour))
+				if err != nil {
+					t.Fatalf("GenerateInviteToken() error = %v", err)
+				}
+				return token
+			},
+			wantErr: "invalid or expired invitation token",
+		},
+		{
+			name: "Invitation expired after the token was issued",
+			token: func(t *testing.T, s *testServices, invitation *InvitationWithToken) string {
+				if err := s.db.Model(&invitation.Invitation).Update("expires_at", time.Now().Add(-time.Hour)).Error; err != nil {
+					t.Fatalf("failed to expire invitation: %v", err)
+				}
+				return invitation.Token
+			},
+			wantErr: "invalid or expired invitation token",
+		},
+		{
+			name: "Signed with another secret",
+			token: func(t *testing.T, s *testServices, invitation *InvitationWithToken) string {
+				token, err := utils.GenerateInviteToken(invitation.Invitation.ID, invitation.Invitation.GroupID, invitation.Invitation.Email, "other-secret", invitation.Invitation.ExpiresAt)
+				if err != nil {
+					t.Fatalf("GenerateInviteToken() error = %v", err)
+				}
+				return token
+			},
+			wantErr: "invalid or expired invitation token",
+		},
+	}
+
+	for _, tt := range refusals {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServices(t)
+			alice := s.createUser(t, "alice")
+			bob := s.createUser(t, "bob")
+			group := s.createGroup(t, alice)
+			invitation := s.invite(t, group.ID, alice, "bob@example.com")
+
+			token := tt.token(t, s, invitation)
+			if _, err := s.invitation.AcceptInvitation(bob.ID, RespondInvitationRequest{Token: token}); err == nil || err.Error() != tt.wantErr {
+				t.Fatalf("AcceptInvitation() error = %v, want %s", err, tt.wantErr)
+			}
+			if s.group.IsUserMember(group.ID, bob.ID) {
+				t.Error("bob became a member despite the refusal")
+			}
+		})
+	}
+}
+
+func TestGetUserInvitationsSkipsExpired(t *testing.T) {
+	s := newTestServices(t)
+	alice := s.createUser(t, "alice")
+	bob := s.createUser(t, "bob")
+	current := s.createGroup(t, alice)
+	expired := s.createGroup(t, alice)
+	s.invite(t, current.ID, alice, "bob@example.com")
+	stale := s.invite(t, expired.ID, alice, "bob@example.com")
+	if err := s.db.Model(&stale.Invitation).Update("expires_at", time.Now().Add(-time.Hour)).Error; err != nil {
+		t.Fatalf("failed to expire invitation: %v", err)
+	}
+
+	invitations, err := s.invitation.GetUserInvitations(bob.ID)
+	if err != nil {
+		t.Fatalf("GetUserInvitations() error = %v", err)
+	}
+	if len(invitations) != 1 || invitations[0].Invitation.GroupID != current.ID || invitations[0].Token == "" {
+		t.Errorf("GetUserInvitations() = %+v, want only the invitation to group %d", invitations, current.ID)
+	}
+}
+
+func TestRegisterAttachesPendingInvitations(t *testing.T) {
+	s := newTestServices(t)
+	alice := s.createUser(t, "alice")
+	group := s.createGroup(t, alice)
+	invitation := s.invite(t, group.ID, alice, "dave@example.com")
+	if invitation.Invitation.InviteeID != nil {
+		t.Fatalf("invitation to an unknown email has invitee %d", *invitation.Invitation.InviteeID)
+	}
+
+	registered, err := s.auth.Register(RegisterRequest{Email: " Dave@Example.com", Password: "secret123", Name: "Dave"})
+	if err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+
+	var stored models.GroupInvitation
+	if err := s.db.First(&stored, invitation.Invitation.ID).Error; err != nil {
+		t.Fatalf("failed to reload invitation: %v", err)
+	}
+	if stored.InviteeID == nil || *stored.InviteeID != registered.User.ID {
+		t.Errorf("invitation invitee = %v, want %d", stored.InviteeID, registered.User.ID)
+	}
+	if stored.Status != "pending" {
+		t.Errorf("invitation status = %s, want pending", stored.Status)
+	}
+
+	invitations, err := s.invitation.GetUserInvitations(registered.User.ID)
+	if err != nil {
+		t.Fatalf("GetUserInvitations() error = %v", err)
+	}
+	if len(invitations) != 1 || invitations[0].Invitation.ID != invitation.Invitation.ID {
+		t.Fatalf("GetUserInvitations() = %+v, want the invitation sent before registering", invitations)
+	}
+
+	if _, err := s.invitation.AcceptInvitation(registered.User.ID, RespondInvitationRequest{Token: invitations[0].Token}); err != nil {
+		t.Fatalf("AcceptInvitation() error = %v", err)
+	}
+	if !s.group.IsUserMember(group.ID, registered.User.ID) {
+		t.Error("dave is not a member after accepting")
+	}
+}
+
+func TestAcceptInvitationClaimsPlaceholder(t *testing.T) {
+	s := newTestServices(t)
+	alice := s.createUser(t, "alice")
+	bob := s.createUser(t, "bob")
+	grandma := s.createPlaceholder(t, "grandma")
+	group := s.createGroup(t, alice, grandma)
+
+	// Alice paid for something of grandma's, and recorded a shared bill grandma paid for
+	owned := s.createFinalizedBill(t, group.ID, alice, "20.00", grandma)
+	paid, err := s.bill.CreateBill(alice.ID, CreateBillRequest{
+		GroupID:     group.ID,
+		Title:       "Dinner",
+		PaidByID:    grandma.ID,
+		TotalAmount: decimal.RequireFromString("10.00"),
+		Items:       []CreateBillItemRequest{{Name: "Dinner", Amount: decimal.RequireFromString("10.00"), IsShared: true}},
+	})
+	if err != nil {
+		t.Fatalf("CreateBill() error = %v", err)
+	}
+	if err := s.bill.FinalizeBill(paid.ID, alice.ID); err != nil {
+		t.Fatalf("FinalizeBill() error = %v", err)
+	}
+
+	// Grandma owes alice 15 and has paid 5 of it
+	settlement := s.createSettlement(t, group.ID, alice, owned, *paid)
+	if err := s.settlement.ConfirmSettlement(settlement.ID, alice.ID); err != nil {
+		t.Fatalf("ConfirmSettlement() error = %v", err)
+	}
+	fromGrandma := transactionFrom(t, settlement, grandma)
+	if _, err := s.settlement.RecordPayment(settlement.ID, fromGrandma.ID, alice.ID, RecordPaymentRequest{Amount: decimal.NewFromInt(5)}); err != nil {
+		t.Fatalf("RecordPayment() error = %v", err)
+	}
+
+	invitation, err := s.invitation.CreateInvitation(group.ID, alice.ID, CreateInvitationRequest{Email: "bob@example.com", PlaceholderID: &grandma.ID})
+	if err != nil {
+		t.Fatalf("CreateInvitation() error = %v", err)
+	}
+	if _, err := s.invitation.AcceptInvitation(bob.ID, RespondInvitationRequest{Token: invitation.Token}); err != nil {
+		t.Fatalf("AcceptInvitation() error = %v", err)
+	}
+
+	// Nothing refers to the placeholder any more, and the placeholder itself is gone
+	for _, ref := range placeholderReferences {
+		var count int64
+		if err := s.db.Unscoped().Model(ref.model).Where(ref.column+" = ?", grandma.ID).Count(&count).Error; err != nil {
+			t.Fatalf("failed to count %T.%s: %v", ref.model, ref.column, err)
+		}
+		if count != 0 {
+			t.Errorf("%d %T rows still have %s = placeholder", count, ref.model, ref.column)
+		}
+	}
+	if err := s.db.First(&models.User{}, grandma.ID).Error; err == nil {
+		t.Error("placeholder account still exists")
+	}
+
+	if !s.group.IsUserMember(group.ID, bob.ID) || s.group.IsUserAdmin(group.ID, bob.ID) {
+		t.Error("bob did not take over grandma's membership")
+	}
+
+	var bill models.Bill
+	if err := s.db.First(&bill, paid.ID).Error; err != nil {
+		t.Fatalf("failed to reload bill: %v", err)
+	}
+	if bill.PaidByID != bob.ID || bill.CreatedByID != alice.ID {
+		t.Errorf("bill paid by %d and created by %d, want paid by bob (%d) and created by alice (%d)", bill.PaidByID, bill.CreatedByID, bob.ID, alice.ID)
+	}
+
+	var owners []models.ItemOwner
+	if err := s.db.Joins("JOIN bill_items ON bill_items.id = item_owners.item_id").Where("bill_items.bill_id = ?", owned.ID).Find(&owners).Error; err != nil {
+		t.Fatalf("failed to get item owners: %v", err)
+	}
+	if len(owners) != 1 || owners[0].UserID != bob.ID {
+		t.Errorf("item owners = %+v, want bob", owners)
+	}
+
+	var transaction models.SettlementTransaction
+	if err := s.db.First(&transaction, fromGrandma.ID).Error; err != nil {
+		t.Fatalf("failed to reload transaction: %v", err)
+	}
+	if transaction.FromUserID != bob.ID || !transaction.PaidAmount.Equal(decimal.NewFromInt(5)) || !transaction.RemainingAmount.Equal(decimal.NewFromInt(10)) {
+		t.Errorf("transaction from %d paid %s with %s remaining, want from bob (%d) paid 5 with 10 remaining",
+			transaction.FromUserID, transaction.PaidAmount, transaction.RemainingAmount, bob.ID)
+	}
+
+	if got := s.ledgerBalance(t, group.ID, bob); !got.Equal(decimal.NewFromInt(-10)) {
+		t.Errorf("bob's balance = %s, want -10", got)
+	}
+	if got := s.ledgerBalance(t, group.ID, alice); !got.Equal(decimal.NewFromInt(10)) {
+		t.Errorf("alice's balance = %s, want 10", got)
+	}
+}
@@ -0,0 +1,181 @@
+package services
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/JacksonYuKe/sharedcart-backend/internal/database"
+	"github.com/JacksonYuKe/sharedcart-backend/internal/models"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// LedgerService keeps the running balance of every member in a group
+type LedgerService struct {
+	db *gorm.DB
+}
+
+// NewLedgerService creates a new ledger service
+func NewLedgerService() *LedgerService {
+	return &LedgerService{
+		db: database.DB,
+	}
+}
+
+// GetBalances retrieves the running balances of a group
+func (s *LedgerService) GetBalances(groupID uint) ([]models.GroupBalance, error) {
+	var balances []models.GroupBalance
+	err := s.db.
+		Where("group_id = ?", groupID).
+		Preload("User").
+		Order("user_id").
+		Find(&balances).Error
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to get group balances: %w", err)
+	}
+
+	return balances, nil
+}
+
+// recordBill adds a finalized bill's paid and owed amounts to the group balances
+func (s *LedgerService) recordBill(tx *gorm.DB, bill *models.Bill, members []models.GroupMember) error {
+	for userID, amount := range billNetAmounts(bill, members) {
+		if err := s.adjust(tx, bill.GroupID, userID, amount); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restore writes the balances of a group that has no running balances yet
+func (s *LedgerService) restore(tx *gorm.DB, groupID uint, balances map[uint]decimal.Decimal) error {
+	if len(balances) == 0 {
+		return nil
+	}
+
+	// Zero balances are stored too, so the group is not rebuilt again
+	rows := make([]models.GroupBalance, 0, len(balances))
+	for userID, amount := range balances {
+		rows = append(rows, models.GroupBalance{
+			GroupID:   groupID,
+			UserID:    userID,
+			Balance:   amount,
+			UpdatedAt: time.Now(),
+		})
+	}
+
+	if err := tx.Create(&rows).Error; err != nil {
+		return fmt.Errorf("failed to restore balances for group %d: %w", groupID, err)
+	}
+
+	return nil
+}
+
+// ledgerFromHistory works out the running balances that a group's finalized bills and the
+// settlement payments made so far add up to
+func ledgerFromHistory(bills []*models.Bill, members []models.GroupMember, transactions []models.SettlementTransaction) map[uint]decimal.Decimal {
+	balances := make(map[uint]decimal.Decimal)
+	for _, bill := range bills {
+		for userID, amount := range billNetAmounts(bill, members) {
+			balances[userID] = balances[userID].Add(amount)
+		}
+	}
+
+	for _, transaction := range transactions {
+		// Payments on cancelled and voided settlements were taken back out of the balances
+		if transaction.Status == "cancelled" {
+			continue
+		}
+		paid := recordedPayment(&transaction)
+		if paid.IsZero() {
+			continue
+		}
+		balances[transaction.FromUserID] = balances[transaction.FromUserID].Add(paid)
+		balances[transaction.ToUserID] = balances[transaction.ToUserID].Sub(paid)
+	}
+
+	return balances
+}
+
+// recordedPayment returns how much has been paid towards a settlement transaction
+func recordedPayment(transaction *models.SettlementTransaction) decimal.Decimal {
+	// Transactions paid before partial payments were recorded only have their status set
+	if transaction.PaidAmount.IsZero() && transaction.Status == "paid" {
+		return transaction.Amount
+	}
+	return transaction.PaidAmount
+}
+
+// recordPayment moves a settlement payment between two members' balances
+func (s *LedgerService) recordPayment(tx *gorm.DB, groupID, fromUserID, toUserID uint, amount decimal.Decimal) error {
+	// The payer owes less and the receiver is owed less
+	if err := s.adjust(tx, groupID, fromUserID, amount); err != nil {
+		return err
+	}
+	return s.adjust(tx, groupID, toUserID, amount.Neg())
+}
+
+// adjust adds an amount to a member's running balance, creating the row if needed
+func (s *LedgerService) adjust(tx *gorm.DB, groupID, userID uint, amount decimal.Decimal) error {
+	if amount.IsZero() {
+		return nil
+	}
+
+	balance := models.GroupBalance{
+		GroupID:   groupID,
+		UserID:    userID,
+		Balance:   amount,
+		UpdatedAt: time.Now(),
+	}
+
+	err := tx.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "group_id"}, {Name: "user_id"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"balance":    gorm.Expr("group_balances.balance + excluded.balance"),
+			"updated_at": gorm.Expr("excluded.updated_at"),
+		}),
+	}).Create(&balance).Error
+
+	if err != nil {
+		return fmt.Errorf("failed to update balance for user %d: %w", userID, err)
+	}
+
+	return nil
+}
+
+// balanceOf returns a member's running balance, locking the row until the transaction ends
+func (s *LedgerService) balanceOf(tx *gorm.DB, groupID, userID uint) (decimal.Decimal, error) {
+	var balances []models.GroupBalance
+	err := tx.
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("group_id = ? AND user_id = ?", groupID, userID).
+		Find(&balances).Error
+
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("failed to get balance for user %d: %w", userID, err)
+	}
+	if len(balances) == 0 {
+		return decimal.Zero, nil
+	}
+	return balances[0].Balance, nil
+}
+
+// transferBalance clears a member's balance by spreading it evenly over the given members
+func (s *LedgerService) transferBalance(tx *gorm.DB, groupID, fromUserID uint, amount decimal.Decimal, toUserIDs []uint) error {
+	weights := make(map[uint]decimal.Decimal, len(toUserIDs))
+	for _, userID := range toUserIDs {
+		weights[userID] = decimal.NewFromInt(1)
+	}
+
+	if err := s.adjust(tx, groupID, fromUserID, amount.Neg()); err != nil {
+		return err
+	}
+	for userID, share := range allocateByWeight(amount, weights, minorUnitPlaces) {
+		if err := s.adjust(tx, groupID, userID, share); err != nil {
+			return err
+		}
+	}
+	return nil
+}
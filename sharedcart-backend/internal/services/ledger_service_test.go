@@ -0,0 +1,51 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/JacksonYuKe/sharedcart-backend/internal/models"
+	"github.com/shopspring/decimal"
+)
+
+func TestLedgerFromHistory(t *testing.T) {
+	members := []models.GroupMember{{UserID: 1}, {UserID: 2}, {UserID: 3}}
+	bills := []*models.Bill{
+		{
+			TotalAmount: decimal.RequireFromString("30.00"),
+			PaidByID:    1,
+			Items: []models.BillItem{
+				{Amount: decimal.RequireFromString("30.00"), Quantity: decimal.NewFromInt(1), IsShared: true},
+			},
+		},
+		{
+			TotalAmount: decimal.RequireFromString("12.00"),
+			PaidByID:    2,
+			Items: []models.BillItem{
+				{Amount: decimal.RequireFromString("12.00"), Quantity: decimal.NewFromInt(1), ItemOwners: []models.ItemOwner{{UserID: 3}}},
+			},
+		},
+	}
+	transactions := []models.SettlementTransaction{
+		// Paid before partial payments were recorded
+		{FromUserID: 3, ToUserID: 1, Amount: decimal.RequireFromString("10.00"), Status: "paid"},
+		{FromUserID: 3, ToUserID: 2, Amount: decimal.RequireFromString("12.00"), PaidAmount: decimal.RequireFromString("5.00"), Status: "partially_paid"},
+		{FromUserID: 3, ToUserID: 1, Amount: decimal.RequireFromString("10.00"), Status: "cancelled"},
+		// Reversed when its settlement was voided
+		{FromUserID: 3, ToUserID: 2, Amount: decimal.RequireFromString("2.00"), PaidAmount: decimal.RequireFromString("2.00"), Status: "cancelled"},
+	}
+
+	balances := ledgerFromHistory(bills, members, transactions)
+
+	// Bills alone: 1 is owed 20, 2 is owed 2, 3 owes 22
+	want := map[uint]string{1: "10.00", 2: "-3.00", 3: "-7.00"}
+	sum := decimal.Zero
+	for userID, amount := range want {
+		if !balances[userID].Equal(decimal.RequireFromString(amount)) {
+			t.Errorf("user %d balance = %s, want %s", userID, balances[userID], amount)
+		}
+		sum = sum.Add(balances[userID])
+	}
+	if !sum.IsZero() {
+		t.Errorf("balances sum to %s, want 0", sum)
+	}
+}
@@ -0,0 +1,151 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/JacksonYuKe/sharedcart-backend/internal/database"
+	"github.com/JacksonYuKe/sharedcart-backend/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// PaymentPreferenceService handles whom members prefer to pay or refuse to pay
+type PaymentPreferenceService struct {
+	db           *gorm.DB
+	groupService *GroupService
+}
+
+// NewPaymentPreferenceService creates a new payment preference service
+func NewPaymentPreferenceService(groupService *GroupService) *PaymentPreferenceService {
+	return &PaymentPreferenceService{
+		db:           database.DB,
+		groupService: groupService,
+	}
+}
+
+// SetPaymentPreferenceRequest represents a preference for paying another member
+type SetPaymentPreferenceRequest struct {
+	TargetUserID uint   `json:"target_user_id" binding:"required"`
+	Kind         string `json:"kind" binding:"required,oneof=prefer block"`
+}
+
+// paymentConstraints maps a payer to the receivers they prefer or block
+type paymentConstraints map[uint]map[uint]string
+
+// appliesTo reports whether any constraint involves a debtor and a creditor among the balances
+func (c paymentConstraints) appliesTo(outstanding []*UserBalance) bool {
+	for _, debtor := range outstanding {
+		if !debtor.Balance.IsNegative() {
+			continue
+		}
+		for _, creditor := range outstanding {
+			if creditor.Balance.IsPositive() && c[debtor.UserID][creditor.UserID] != "" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// GetPaymentPreferences returns the caller's payment preferences in a group
+func (s *PaymentPreferenceService) GetPaymentPreferences(groupID, userID uint) ([]models.PaymentPreference, error) {
+	if !s.groupService.IsUserMember(groupID, userID) {
+		return nil, errors.New("user is not a member of this group")
+	}
+
+	var preferences []models.PaymentPreference
+	err := s.db.
+		Where("group_id = ? AND user_id = ?", groupID, userID).
+		Preload("TargetUser").
+		Order("target_user_id").
+		Find(&preferences).Error
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch payment preferences: %w", err)
+	}
+
+	return preferences, nil
+}
+
+// SetPaymentPreference creates or replaces the caller's preference for paying another member
+func (s *PaymentPreferenceService) SetPaymentPreference(groupID, userID uint, req SetPaymentPreferenceRequest) (*models.PaymentPreference, error) {
+	if !s.groupService.IsUserMember(groupID, userID) {
+		return nil, errors.New("user is not a member of this group")
+	}
+
+	if req.TargetUserID == userID {
+		return nil, errors.New("cannot set a payment preference for yourself")
+	}
+
+	if !s.groupService.IsUserMember(groupID, req.TargetUserID) {
+		return nil, errors.New("target user is not a member of this group")
+	}
+
+	preference := models.PaymentPreference{
+		GroupID:      groupID,
+		UserID:       userID,
+		TargetUserID: req.TargetUserID,
+		Kind:         req.Kind,
+	}
+
+	err := s.db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "group_id"}, {Name: "user_id"}, {Name: "target_user_id"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"kind":       req.Kind,
+			"updated_at": time.Now(),
+		}),
+	}).Create(&preference).Error
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to save payment preference: %w", err)
+	}
+
+	if err := s.db.
+		Where("group_id = ? AND user_id = ? AND target_user_id = ?", groupID, userID, req.TargetUserID).
+		Preload("TargetUser").
+		First(&preference).Error; err != nil {
+		return nil, fmt.Errorf("failed to load payment preference: %w", err)
+	}
+
+	return &preference, nil
+}
+
+// DeletePaymentPreference removes the caller's preference for paying another member
+func (s *PaymentPreferenceService) DeletePaymentPreference(groupID, userID, targetUserID uint) error {
+	if !s.groupService.IsUserMember(groupID, userID) {
+		return errors.New("user is not a member of this group")
+	}
+
+	result := s.db.
+		Where("group_id = ? AND user_id = ? AND target_user_id = ?", groupID, userID, targetUserID).
+		Delete(&models.PaymentPreference{})
+
+	if result.Error != nil {
+		return fmt.Errorf("failed to delete payment preference: %w", result.Error)
+	}
+
+	if result.RowsAffected == 0 {
+		return errors.New("payment preference not found")
+	}
+
+	return nil
+}
+
+// paymentConstraints loads every member's payment preferences in a group
+func (s *PaymentPreferenceService) paymentConstraints(groupID uint) (paymentConstraints, error) {
+	var preferences []models.PaymentPreference
+	if err := s.db.Where("group_id = ?", groupID).Find(&preferences).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch payment preferences: %w", err)
+	}
+
+	constraints := make(paymentConstraints)
+	for _, preference := range preferences {
+		if constraints[preference.UserID] == nil {
+			constraints[preference.UserID] = make(map[uint]string)
+		}
+		constraints[preference.UserID][preference.TargetUserID] = preference.Kind
+	}
+	return constraints, nil
+}
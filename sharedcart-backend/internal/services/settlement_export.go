@@ -0,0 +1,281 @@
+package services
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/JacksonYuKe/sharedcart-backend/internal/models"
+	"github.com/JacksonYuKe/sharedcart-backend/pkg/pdf"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+)
+
+// SettlementExport is a rendered settlement statement
+type SettlementExport struct {
+	FileName    string
+	ContentType string
+	Data        []byte
+}
+
+// settlementStatement gathers everything a settlement statement shows
+type settlementStatement struct {
+	settlement *models.Settlement
+	balances   []UserBalance
+}
+
+// ExportSettlement renders a settlement with its bills, items, balances and transactions as csv or pdf
+func (s *SettlementService) ExportSettlement(settlementID, userID uint, format string) (*SettlementExport, error) {
+	if format != "csv" && format != "pdf" {
+		return nil, errors.New("format must be csv or pdf")
+	}
+
+	var settlement models.Settlement
+	err := s.db.
+		Preload("Group").
+		Preload("CreatedBy").
+		Preload("Bills", func(db *gorm.DB) *gorm.DB { return db.Order("bill_date, id") }).
+		Preload("Bills.PaidBy").
+		Preload("Bills.Items", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).
+		Preload("Transactions", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).
+		Preload("Transactions.FromUser").
+		Preload("Transactions.ToUser").
+		Preload("Balances", func(db *gorm.DB) *gorm.DB { return db.Order("user_id") }).
+		Preload("Balances.User").
+		First(&settlement, settlementID).Error
+
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("settlement not found")
+		}
+		return nil, fmt.Errorf("failed to get settlement: %w", err)
+	}
+
+	// Verify user has access
+	if !s.groupService.IsUserMember(settlement.GroupID, userID) {
+		return nil, errors.New("user is not authorized to view this settlement")
+	}
+
+	statement := settlementStatement{settlement: &settlement}
+	for _, balance := range settlement.Balances {
+		userBalance := UserBalance{
+			UserID:  balance.UserID,
+			Paid:    balance.Paid,
+			Owes:    balance.Owes,
+			Balance: balance.Balance,
+		}
+		if balance.User != nil {
+			userBalance.UserName = balance.User.Name
+			userBalance.IsPlaceholder = balance.User.IsPlaceholder
+		}
+		statement.balances = append(statement.balances, userBalance)
+	}
+
+	// Settlements created before balances were stored work them out again from their bills,
+	// leaving out payment preferences, which only affect the transactions
+	if len(settlement.Balances) == 0 && len(settlement.Bills) > 0 {
+		billIDs := make([]uint, len(settlement.Bills))
+		for i, bill := range settlement.Bills {
+			billIDs[i] = bill.ID
+		}
+		result, _, err := s.calculateBalances(settlement.GroupID, billIDs, false)
+		if err != nil {
+			return nil, err
+		}
+		statement.balances = result.Balances
+	}
+
+	fileName := fmt.Sprintf("settlement-%d.%s", settlement.ID, format)
+	if format == "csv" {
+		data, err := statement.csv()
+		if err != nil {
+			return nil, fmt.Errorf("failed to render settlement: %w", err)
+		}
+		return &SettlementExport{FileName: fileName, ContentType: "text/csv", Data: data}, nil
+	}
+
+	return &SettlementExport{FileName: fileName, ContentType: "application/pdf", Data: statement.pdf()}, nil
+}
+
+// csv renders the statement as one csv file with a blank line between sections
+func (st *settlementStatement) csv() ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+
+	sections := [][][]string{
+		append([][]string{{"Settlement"}}, st.summary()...),
+		append([][]string{{"Bills"}, billColumns}, st.billRows()...),
+		append([][]string{{"Items"}, itemColumns}, st.itemRows()...),
+		append([][]string{{"Balances"}, balanceColumns}, st.balanceRows()...),
+		append([][]string{{"Transactions"}, transactionColumns}, st.transactionRows()...),
+	}
+	for i, section := range sections {
+		if i > 0 {
+			if err := w.Write(nil); err != nil {
+				return nil, err
+			}
+		}
+		for _, record := range section {
+			safe := make([]string, len(record))
+			for j, cell := range record {
+				safe[j] = csvSafe(cell)
+			}
+			if err := w.Write(safe); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// csvSafe stops spreadsheets from running a cell as a formula by prefixing it with a quote.
+// Plain numbers such as negative balances are left alone.
+func csvSafe(cell string) string {
+	if cell == "" || !strings.ContainsRune("=+-@\t\r", rune(cell[0])) {
+		return cell
+	}
+	if _, err := decimal.NewFromString(cell); err == nil {
+		return cell
+	}
+	return "'" + cell
+}
+
+// pdf renders the statement as a printable document
+func (st *settlementStatement) pdf() []byte {
+	doc := pdf.New()
+	doc.Title(st.settlement.Title)
+	for _, row := range st.summary() {
+		doc.Text(row[0] + ": " + row[1])
+	}
+
+	doc.Heading("Bills")
+	doc.Table([]float64{40, 70, 170, 110, 60, 60}, billColumns, st.billRows())
+
+	doc.Heading("Items")
+	doc.Table([]float64{40, 160, 60, 70, 60, 80, 40}, itemColumns, st.itemRows())
+
+	doc.Heading("Balances")
+	doc.Table([]float64{160, 90, 90, 90}, balanceColumns, st.balanceRows())
+
+	doc.Heading("Transactions")
+	doc.Table([]float64{110, 110, 60, 40, 60, 90}, transactionColumns, st.transactionRows())
+
+	return doc.Bytes()
+}
+
+var (
+	billColumns        = []string{"Bill", "Date", "Title", "Paid By", "Amount", "Currency"}
+	itemColumns        = []string{"Bill", "Item", "Quantity", "Unit", "Amount", "Split", "Shared"}
+	balanceColumns     = []string{"Member", "Paid", "Owes", "Balance"}
+	transactionColumns = []string{"From", "To", "Amount", "Currency", "Paid", "Status"}
+)
+
+func (st *settlementStatement) summary() [][]string {
+	settlement := st.settlement
+
+	rows := [][]string{
+		{"Title", settlement.Title},
+		{"Status", settlement.Status},
+		{"Currency", settlement.Currency},
+		{"Created", settlement.CreatedAt.Format(dateLayout)},
+	}
+	if settlement.Group != nil {
+		rows = append(rows, []string{"Group", settlement.Group.Name})
+	}
+	if settlement.CreatedBy != nil {
+		rows = append(rows, []string{"Created By", settlement.CreatedBy.Name})
+	}
+	if settlement.SettledAt != nil {
+		rows = append(rows, []string{"Settled", settlement.SettledAt.Format(dateLayout)})
+	}
+	if settlement.CancelledAt != nil {
+		rows = append(rows, []string{"Cancelled", settlement.CancelledAt.Format(dateLayout)}, []string{"Reason", settlement.CancelReason})
+	}
+	return rows
+}
+
+func (st *settlementStatement) billRows() [][]string {
+	rows := make([][]string, 0, len(st.settlement.Bills))
+	for _, bill := range st.settlement.Bills {
+		paidBy := ""
+		if bill.PaidBy != nil {
+			paidBy = bill.PaidBy.Name
+		}
+		rows = append(rows, []string{
+			strconv.FormatUint(uint64(bill.ID), 10),
+			bill.BillDate.Format(dateLayout),
+			bill.Title,
+			paidBy,
+			bill.TotalAmount.StringFixed(minorUnitPlaces),
+			bill.Currency,
+		})
+	}
+	return rows
+}
+
+func (st *settlementStatement) itemRows() [][]string {
+	var rows [][]string
+	for _, bill := range st.settlement.Bills {
+		for _, item := range bill.Items {
+			rows = append(rows, []string{
+				strconv.FormatUint(uint64(bill.ID), 10),
+				item.Name,
+				item.Quantity.String(),
+				item.Unit,
+				item.Amount.StringFixed(minorUnitPlaces),
+				item.SplitMode,
+				strconv.FormatBool(item.IsShared),
+			})
+		}
+	}
+	return rows
+}
+
+func (st *settlementStatement) balanceRows() [][]string {
+	balances := append([]UserBalance(nil), st.balances...)
+	sort.SliceStable(balances, func(i, j int) bool {
+		return balances[i].UserName < balances[j].UserName
+	})
+
+	rows := make([][]string, 0, len(balances))
+	for _, balance := range balances {
+		rows = append(rows, []string{
+			balance.UserName,
+			balance.Paid.StringFixed(minorUnitPlaces),
+			balance.Owes.StringFixed(minorUnitPlaces),
+			balance.Balance.StringFixed(minorUnitPlaces),
+		})
+	}
+	return rows
+}
+
+func (st *settlementStatement) transactionRows() [][]string {
+	rows := make([][]string, 0, len(st.settlement.Transactions))
+	for _, transaction := range st.settlement.Transactions {
+		from, to := "", ""
+		if transaction.FromUser != nil {
+			from = transaction.FromUser.Name
+		}
+		if transaction.ToUser != nil {
+			to = transaction.ToUser.Name
+		}
+		rows = append(rows, []string{
+			from,
+			to,
+			transaction.Amount.StringFixed(minorUnitPlaces),
+			transaction.Currency,
+			transaction.PaidAmount.StringFixed(minorUnitPlaces),
+			transaction.Status,
+		})
+	}
+	return rows
+}
@@ -0,0 +1,103 @@
+package services
+
+import (
+	"encoding/csv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JacksonYuKe/sharedcart-backend/internal/models"
+	"github.com/shopspring/decimal"
+)
+
+func TestSettlementStatementCSV(t *testing.T) {
+	alice := &models.User{ID: 1, Name: "Alice"}
+	bob := &models.User{ID: 2, Name: "Bob"}
+
+	statement := settlementStatement{
+		settlement: &models.Settlement{
+			ID:        7,
+			Title:     "March groceries",
+			Status:    "confirmed",
+			Currency:  "USD",
+			CreatedAt: time.Date(2024, 3, 31, 12, 0, 0, 0, time.UTC),
+			Bills: []models.Bill{{
+				ID:          3,
+				Title:       "Weekly shop, part 1",
+				TotalAmount: decimal.NewFromInt(30),
+				Currency:    "USD",
+				PaidBy:      alice,
+				BillDate:    time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC),
+				Items: []models.BillItem{
+					{Name: "Milk", Amount: decimal.NewFromInt(10), Quantity: decimal.NewFromInt(2), Unit: "L", SplitMode: "equal", IsShared: true},
+					{Name: "Coffee", Amount: decimal.NewFromInt(20), Quantity: decimal.NewFromInt(1), Unit: "each", SplitMode: "equal"},
+				},
+			}},
+			Transactions: []models.SettlementTransaction{
+				{FromUser: bob, ToUser: alice, Amount: decimal.NewFromInt(5), Currency: "USD", Status: "pending"},
+			},
+		},
+		balances: []UserBalance{
+			{UserID: 2, UserName: "Bob", Paid: decimal.Zero, Owes: decimal.NewFromInt(5), Balance: decimal.NewFromInt(-5)},
+			{UserID: 1, UserName: "Alice", Paid: decimal.NewFromInt(30), Owes: decimal.NewFromInt(25), Balance: decimal.NewFromInt(5)},
+		},
+	}
+
+	data, err := statement.csv()
+	if err != nil {
+		t.Fatalf("csv() error = %v", err)
+	}
+
+	reader := csv.NewReader(strings.NewReader(string(data)))
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		t.Fatalf("statement is not valid csv: %v", err)
+	}
+
+	// Index the rows that follow each section title
+	sections := map[string][][]string{}
+	current := ""
+	for _, record := range records {
+		if len(record) == 1 && (record[0] == "Settlement" || record[0] == "Bills" || record[0] == "Items" || record[0] == "Balances" || record[0] == "Transactions") {
+			current = record[0]
+			continue
+		}
+		sections[current] = append(sections[current], record)
+	}
+
+	if got := sections["Bills"][1]; strings.Join(got, "|") != "3|2024-03-02|Weekly shop, part 1|Alice|30.00|USD" {
+		t.Errorf("bill row = %v", got)
+	}
+	if got := len(sections["Items"]); got != 3 {
+		t.Errorf("got %d item rows including the header, want 3", got)
+	}
+	if got := sections["Balances"][1]; strings.Join(got, "|") != "Alice|30.00|25.00|5.00" {
+		t.Errorf("balances should be sorted by name, first row = %v", got)
+	}
+	if got := sections["Transactions"][1]; strings.Join(got, "|") != "Bob|Alice|5.00|USD|0.00|pending" {
+		t.Errorf("transaction row = %v", got)
+	}
+}
+
+func TestCSVSafe(t *testing.T) {
+	tests := []struct {
+		cell string
+		want string
+	}{
+		{"Milk", "Milk"},
+		{"", ""},
+		{"-5.00", "-5.00"},
+		{"=HYPERLINK(\"http://example.com\")", "'=HYPERLINK(\"http://example.com\")"},
+		{"+1+cmd", "'+1+cmd"},
+		{"-2+3", "'-2+3"},
+		{"@SUM(A1)", "'@SUM(A1)"},
+		{"\tTab", "'\tTab"},
+	}
+
+	for _, tt := range tests {
+		if got := csvSafe(tt.cell); got != tt.want {
+			t.Errorf("csvSafe(%q) = %q, want %q", tt.cell, got, tt.want)
+		}
+	}
+}
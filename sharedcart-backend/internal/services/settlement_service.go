@@ -3,44 +3,109 @@ package services
 import (
 	"errors"
 	"fmt"
+	"math"
+	"math/bits"
+	"slices"
 	"sort"
+	"strings"
 	"time"
 
+	"github.com/JacksonYuKe/sharedcart-backend/config"
 	"github.com/JacksonYuKe/sharedcart-backend/internal/database"
 	"github.com/JacksonYuKe/sharedcart-backend/internal/models"
 	"github.com/shopspring/decimal"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // SettlementService handles settlement calculations and operations
 type SettlementService struct {
-	db           *gorm.DB
-	groupService *GroupService
-	billService  *BillService
+	db            *gorm.DB
+	config        *config.SettlementConfig
+	groupService  *GroupService
+	billService   *BillService
+	ledgerService *LedgerService
+
+	preferenceService   *PaymentPreferenceService
+	exchangeRateService *ExchangeRateService
 }
 
 // NewSettlementService creates a new settlement service
-func NewSettlementService(groupService *GroupService, billService *BillService) *SettlementService {
+func NewSettlementService(cfg *config.SettlementConfig, groupService *GroupService, billService *BillService, ledgerService *LedgerService, preferenceService *PaymentPreferenceService, exchangeRateService *ExchangeRateService) *SettlementService {
 	return &SettlementService{
-		db:           database.DB,
-		groupService: groupService,
-		billService:  billService,
+		db:                  database.DB,
+		config:              cfg,
+		groupService:        groupService,
+		billService:         billService,
+		ledgerService:       ledgerService,
+		preferenceService:   preferenceService,
+		exchangeRateService: exchangeRateService,
 	}
 }
 
+// minorUnitPlaces is the number of decimal places in the currency's minor unit
+const minorUnitPlaces int32 = 2
+
+// maxExactSolverMembers caps the exact solver, whose cost doubles with every outstanding balance
+const maxExactSolverMembers = 20
+
+// BillConflictError reports bills that can't be included in a settlement
+type BillConflictError struct {
+	MissingBillIDs      []uint `json:"missing_bill_ids,omitempty"`       // Bills that don't exist or belong to another group
+	NotFinalizedBillIDs []uint `json:"not_finalized_bill_ids,omitempty"` // Bills that are pending or already settled
+	InSettlementBillIDs []uint `json:"in_settlement_bill_ids,omitempty"` // Bills linked to another non-cancelled settlement
+}
+
+// Error implements the error interface
+func (e *BillConflictError) Error() string {
+	var reasons []string
+	if len(e.MissingBillIDs) > 0 {
+		reasons = append(reasons, fmt.Sprintf("bills %v are not in this group", e.MissingBillIDs))
+	}
+	if len(e.NotFinalizedBillIDs) > 0 {
+		reasons = append(reasons, fmt.Sprintf("bills %v are not finalized", e.NotFinalizedBillIDs))
+	}
+	if len(e.InSettlementBillIDs) > 0 {
+		reasons = append(reasons, fmt.Sprintf("bills %v are already in another settlement", e.InSettlementBillIDs))
+	}
+	return "bills cannot be settled: " + strings.Join(reasons, "; ")
+}
+
 // CalculateSettlementRequest represents settlement calculation input
 type CalculateSettlementRequest struct {
-	GroupID uint   `json:"group_id" binding:"required"`
-	BillIDs []uint `json:"bill_ids" binding:"required,min=1"`
+	GroupID  uint       `json:"group_id" binding:"required"`
+	BillIDs  []uint     `json:"bill_ids" binding:"omitempty,min=1"`                      // Required unless a mode is given
+	Mode     string     `json:"mode" binding:"omitempty,oneof=all_finalized date_range"` // Let the service pick the bills
+	From     *time.Time `json:"from"`                                                    // date_range: earliest bill date, inclusive
+	To       *time.Time `json:"to"`                                                      // date_range: latest bill date, inclusive
+	Strategy string     `json:"strategy" binding:"omitempty,oneof=minimal greedy"`       // Defaults to minimal
+	Detailed bool       `json:"detailed"`                                                // Explain every user's Owes line by line
+}
+
+// RecordPaymentRequest represents a payment towards a settlement transaction
+type RecordPaymentRequest struct {
+	Amount decimal.Decimal `json:"amount"` // In the payment currency if the payer chose one, else the settlement currency; optional, defaults to the remaining amount
+	Notes  string          `json:"notes" binding:"max=500"`
+}
+
+// SetPaymentCurrencyRequest represents the currency a payer wants to pay a transaction in
+type SetPaymentCurrencyRequest struct {
+	Currency string `json:"currency" binding:"required,len=3,uppercase"` // The settlement currency clears an earlier choice
+}
+
+// CancelSettlementRequest represents the reason for cancelling or voiding a settlement
+type CancelSettlementRequest struct {
+	Reason string `json:"reason" binding:"required,max=500"`
 }
 
 // UserBalance represents a user's balance in the settlement
 type UserBalance struct {
-	UserID   uint            `json:"user_id"`
-	UserName string          `json:"user_name"`
-	Paid     decimal.Decimal `json:"paid"`    // Total amount paid by user
-	Owes     decimal.Decimal `json:"owes"`    // Total amount user owes
-	Balance  decimal.Decimal `json:"balance"` // Paid - Owes (positive means user should receive)
+	UserID        uint            `json:"user_id"`
+	UserName      string          `json:"user_name"`
+	IsPlaceholder bool            `json:"is_placeholder,omitempty"` // Participant without an account
+	Paid          decimal.Decimal `json:"paid"`                     // Total amount paid by user
+	Owes          decimal.Decimal `json:"owes"`                     // Total amount user owes
+	Balance       decimal.Decimal `json:"balance"`                  // Paid - Owes (positive means user should receive)
 }
 
 // Transaction represents a payment from one user to another
@@ -50,15 +115,51 @@ type Transaction struct {
 	ToUserID     uint            `json:"to_user_id"`
 	ToUserName   string          `json:"to_user_name"`
 	Amount       decimal.Decimal `json:"amount"`
+	Currency     string          `json:"currency"`
+}
+
+// OwesLine is one part of what a user owes: their share of an item or adjustment on a bill
+type OwesLine struct {
+	BillID    uint            `json:"bill_id"`
+	BillTitle string          `json:"bill_title"`
+	ItemID    *uint           `json:"item_id,omitempty"`
+	Name      string          `json:"name,omitempty"` // Item or adjustment name
+	Kind      string          `json:"kind"`           // item, adjustment, bill (no items, split equally), rounding
+	SplitRule string          `json:"split_rule"`     // equal, shares, percent, exact, shared (among all members), proportional (to item subtotals), largest_remainder
+	Share     decimal.Decimal `json:"share"`          // Fraction of the item or adjustment the user takes on
+	Amount    decimal.Decimal `json:"amount"`         // In the settlement currency
+}
+
+// UserBreakdown lists the lines that add up to a user's Owes
+type UserBreakdown struct {
+	UserID   uint            `json:"user_id"`
+	UserName string          `json:"user_name"`
+	Owes     decimal.Decimal `json:"owes"`
+	Lines    []OwesLine      `json:"lines"`
 }
 
 // SettlementResult represents the complete settlement calculation
 type SettlementResult struct {
 	GroupID      uint            `json:"group_id"`
+	BillIDs      []uint          `json:"bill_ids"` // Bills included in the calculation
 	BillCount    int             `json:"bill_count"`
+	Currency     string          `json:"currency"` // Group currency that every amount is expressed in
 	TotalAmount  decimal.Decimal `json:"total_amount"`
 	Balances     []UserBalance   `json:"balances"`
 	Transactions []Transaction   `json:"transactions"`
+	Strategy     string          `json:"strategy"` // Strategy actually used; minimal falls back to greedy for large groups, and payment preferences force constrained
+
+	ExchangeRates []AppliedExchangeRate `json:"exchange_rates,omitempty"` // Rates used for bills in other currencies
+	Breakdown     []UserBreakdown       `json:"breakdown,omitempty"`      // Only in detailed mode
+}
+
+// GroupBalancesResult represents the running balances of a group
+type GroupBalancesResult struct {
+	GroupID      uint          `json:"group_id"`
+	Currency     string        `json:"currency"`
+	Balances     []UserBalance `json:"balances"`             // Only Balance is tracked by the ledger
+	Transactions []Transaction `json:"transactions"`         // Suggested payments to clear the balances
+	PlanError    string        `json:"plan_error,omitempty"` // Why no payments could be suggested, such as payment preferences that rule out every plan
 }
 
 // CalculateSettlement calculates how to settle bills for a group
@@ -68,134 +169,998 @@ func (s *SettlementService) CalculateSettlement(userID uint, req CalculateSettle
 		return nil, errors.New("user is not a member of this group")
 	}
 
+	billIDs, err := s.resolveBillIDs(req)
+	if err != nil {
+		return nil, err
+	}
+	if len(billIDs) == 0 {
+		return nil, errors.New("no bills found")
+	}
+
+	// A bill can only be settled once
+	if err := s.checkBillsSettleable(s.db, req.GroupID, billIDs, false); err != nil {
+		return nil, err
+	}
+
+	return s.calculateBills(req.GroupID, billIDs, req.Strategy, req.Detailed)
+}
+
+// calculateBills works out the balances and payments for a set of bills, whether or not they
+// already belong to a settlement, optionally explaining each user's Owes
+func (s *SettlementService) calculateBills(groupID uint, billIDs []uint, strategy string, detailed bool) (*SettlementResult, error) {
+	result, balances, err := s.calculateBalances(groupID, billIDs, detailed)
+	if err != nil {
+		return nil, err
+	}
+
+	// Calculate optimal transactions
+	constraints, err := s.preferenceService.paymentConstraints(groupID)
+	if err != nil {
+		return nil, err
+	}
+	transactions, strategy, err := s.optimizeTransactions(balances, strategy, constraints)
+	if err != nil {
+		return nil, err
+	}
+	for i := range transactions {
+		transactions[i].Currency = result.Currency
+	}
+
+	result.Transactions = transactions
+	result.Strategy = strategy
+	return result, nil
+}
+
+// calculateBalances works out what each member paid and owes across a set of bills, without
+// planning any payments. The balances are also returned keyed by user ID.
+func (s *SettlementService) calculateBalances(groupID uint, billIDs []uint, detailed bool) (*SettlementResult, map[uint]*UserBalance, error) {
 	// Get all bills
 	var bills []models.Bill
 	err := s.db.
-		Where("id IN ? AND group_id = ?", req.BillIDs, req.GroupID).
+		Where("id IN ? AND group_id = ?", billIDs, groupID).
 		Preload("PaidBy").
-		Preload("Items.Owners").
+		Preload("Payers").
+		Preload("Adjustments").
+		Preload("Items.ItemOwners").
+		Order("id").
 		Find(&bills).Error
 
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch bills: %w", err)
+		return nil, nil, fmt.Errorf("failed to fetch bills: %w", err)
 	}
 
 	if len(bills) == 0 {
-		return nil, errors.New("no bills found")
+		return nil, nil, errors.New("no bills found")
+	}
+
+	// Get all group members, including those who have left since
+	members, err := s.groupService.memberHistory(groupID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Initialize balances for all members
+	balances := make(map[uint]*UserBalance)
+	current := make(map[uint]bool)
+	for _, member := range members {
+		if member.LeftAt == nil {
+			current[member.UserID] = true
+		}
+		balances[member.UserID] = &UserBalance{
+			UserID:        member.UserID,
+			UserName:      member.User.Name,
+			IsPlaceholder: member.User.IsPlaceholder,
+			Paid:          decimal.Zero,
+			Owes:          decimal.Zero,
+			Balance:       decimal.Zero,
+		}
+	}
+
+	// Everything is settled in the group currency
+	var group models.Group
+	if err := s.db.Select("id", "currency").First(&group, groupID).Error; err != nil {
+		return nil, nil, errors.New("group not found")
+	}
+
+	// Calculate balances
+	totalAmount := decimal.Zero
+	var appliedRates []AppliedExchangeRate
+	owesLines := make(map[uint][]OwesLine)
+	for i := range bills {
+		// Convert bills entered in another currency at the rate on their bill date
+		bill, rate, err := s.exchangeRateService.convertBill(&bills[i], group.Currency)
+		if err != nil {
+			return nil, nil, err
+		}
+		if rate != nil {
+			appliedRates = append(appliedRates, *rate)
+		}
+
+		// Credit each payer with their portion of the bill
+		billTotal := bill.TotalAmount.Round(minorUnitPlaces)
+		for payerID, amount := range billPaidAmounts(bill, billTotal) {
+			if balance, exists := balances[payerID]; exists {
+				balance.Paid = balance.Paid.Add(amount)
+			}
+		}
+		totalAmount = totalAmount.Add(billTotal)
+
+		// Calculate what each person owes for this bill
+		calculateBillOwes(bill, balances, members)
+		if detailed {
+			for userID, lines := range explainBillOwes(bill, members) {
+				owesLines[userID] = append(owesLines[userID], lines...)
+			}
+		}
+	}
+
+	// Calculate final balances (positive = should receive, negative = should pay)
+	for userID, balance := range balances {
+		// Former members only appear when these bills involve them
+		if !current[userID] && balance.Paid.IsZero() && balance.Owes.IsZero() {
+			delete(balances, userID)
+			continue
+		}
+		balance.Balance = balance.Paid.Sub(balance.Owes)
+	}
+
+	// Convert map to slice for output
+	balanceSlice := make([]UserBalance, 0, len(balances))
+	for _, balance := range balances {
+		balanceSlice = append(balanceSlice, *balance)
+	}
+
+	// Sort by user ID for consistent output
+	sort.Slice(balanceSlice, func(i, j int) bool {
+		return balanceSlice[i].UserID < balanceSlice[j].UserID
+	})
+
+	var breakdown []UserBreakdown
+	if detailed {
+		breakdown = make([]UserBreakdown, 0, len(balanceSlice))
+		for _, balance := range balanceSlice {
+			lines := owesLines[balance.UserID]
+			if lines == nil {
+				lines = []OwesLine{}
+			}
+			breakdown = append(breakdown, UserBreakdown{
+				UserID:   balance.UserID,
+				UserName: balance.UserName,
+				Owes:     balance.Owes,
+				Lines:    lines,
+			})
+		}
 	}
 
-	// Verify all bills belong to the group
+	includedIDs := make([]uint, 0, len(bills))
 	for _, bill := range bills {
-		if bill.GroupID != req.GroupID {
-			return nil, fmt.Errorf("bill %d does not belong to group %d", bill.ID, req.GroupID)
+		includedIDs = append(includedIDs, bill.ID)
+	}
+
+	return &SettlementResult{
+		GroupID:     groupID,
+		BillIDs:     includedIDs,
+		BillCount:   len(bills),
+		Currency:    group.Currency,
+		TotalAmount: totalAmount,
+		Balances:    balanceSlice,
+
+		ExchangeRates: appliedRates,
+		Breakdown:     breakdown,
+	}, balances, nil
+}
+
+// resolveBillIDs returns the bills a settlement request covers, selecting them itself when a mode is given
+func (s *SettlementService) resolveBillIDs(req CalculateSettlementRequest) ([]uint, error) {
+	if req.Mode == "" {
+		if len(req.BillIDs) == 0 {
+			return nil, errors.New("bill_ids are required when no mode is given")
+		}
+		return req.BillIDs, nil
+	}
+	if len(req.BillIDs) > 0 {
+		return nil, errors.New("bill_ids cannot be combined with a mode")
+	}
+
+	// Finalized bills that no pending or confirmed settlement has claimed yet
+	query := s.db.Model(&models.Bill{}).
+		Where("group_id = ? AND status = ?", req.GroupID, "finalized").
+		Where("id NOT IN (?)", s.db.Model(&models.SettlementBill{}).
+			Select("settlement_bills.bill_id").
+			Joins("JOIN settlements ON settlements.id = settlement_bills.settlement_id").
+			Where("settlements.deleted_at IS NULL AND settlements.status NOT IN ?", []string{"cancelled", "voided"}))
+
+	if req.Mode == "date_range" {
+		if req.From == nil || req.To == nil {
+			return nil, errors.New("date_range mode requires from and to")
+		}
+		start, end := billDateRange(*req.From, *req.To)
+		if !start.Before(end) {
+			return nil, errors.New("from must not be after to")
+		}
+		query = query.Where("bill_date >= ? AND bill_date < ?", start, end)
+	}
+
+	var billIDs []uint
+	if err := query.Order("id").Pluck("id", &billIDs).Error; err != nil {
+		return nil, fmt.Errorf("failed to select bills: %w", err)
+	}
+	return billIDs, nil
+}
+
+// billDateRange turns an inclusive range of bill dates into the half-open range of timestamps
+// it covers, so bills from any time on the last day are included
+func billDateRange(from, to time.Time) (time.Time, time.Time) {
+	return calendarDay(from), calendarDay(to).AddDate(0, 0, 1)
+}
+
+// billPaidAmounts returns how much of the bill total each payer covered
+func billPaidAmounts(bill *models.Bill, billTotal decimal.Decimal) map[uint]decimal.Decimal {
+	if len(bill.Payers) == 0 {
+		return map[uint]decimal.Decimal{bill.PaidByID: billTotal}
+	}
+
+	// Spread the total by payer amounts so the credits always add up to it exactly
+	weights := make(map[uint]decimal.Decimal, len(bill.Payers))
+	for _, payer := range bill.Payers {
+		weights[payer.UserID] = weights[payer.UserID].Add(payer.Amount)
+	}
+	return allocateByWeight(billTotal, weights, minorUnitPlaces)
+}
+
+// billNetAmounts returns each member's paid minus owed amount for a single bill
+func billNetAmounts(bill *models.Bill, members []models.GroupMember) map[uint]decimal.Decimal {
+	balances := make(map[uint]*UserBalance, len(members))
+	for _, member := range members {
+		balances[member.UserID] = &UserBalance{UserID: member.UserID}
+	}
+
+	billTotal := bill.TotalAmount.Round(minorUnitPlaces)
+	for payerID, amount := range billPaidAmounts(bill, billTotal) {
+		if balance, exists := balances[payerID]; exists {
+			balance.Paid = balance.Paid.Add(amount)
 		}
 	}
+	calculateBillOwes(bill, balances, members)
+
+	net := make(map[uint]decimal.Decimal, len(balances))
+	for userID, balance := range balances {
+		net[userID] = balance.Paid.Sub(balance.Owes)
+	}
+	return net
+}
 
-	// Get all group members
-	members, err := s.groupService.GetGroupMembers(req.GroupID, userID)
+// GetGroupBalances returns who owes whom right now, based on every finalized bill and paid transaction
+func (s *SettlementService) GetGroupBalances(groupID, userID uint) (*GroupBalancesResult, error) {
+	members, err := s.groupService.GetGroupMembers(groupID, userID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get group members: %w", err)
+		return nil, err
 	}
 
-	// Initialize balances for all members
+	var group models.Group
+	if err := s.db.Select("id", "currency").First(&group, groupID).Error; err != nil {
+		return nil, errors.New("group not found")
+	}
+
+	ledger, err := s.ledgerService.GetBalances(groupID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Every member appears, even before their first bill
 	balances := make(map[uint]*UserBalance)
 	for _, member := range members {
 		balances[member.UserID] = &UserBalance{
-			UserID:   member.UserID,
-			UserName: member.User.Name,
-			Paid:     decimal.Zero,
-			Owes:     decimal.Zero,
-			Balance:  decimal.Zero,
+			UserID:        member.UserID,
+			UserName:      member.User.Name,
+			IsPlaceholder: member.User.IsPlaceholder,
 		}
 	}
+	for _, entry := range ledger {
+		balance, exists := balances[entry.UserID]
+		if !exists {
+			// Former members keep their balance until it is settled
+			balance = &UserBalance{UserID: entry.UserID}
+			if entry.User != nil {
+				balance.UserName = entry.User.Name
+			}
+			balances[entry.UserID] = balance
+		}
+		balance.Balance = entry.Balance
+	}
 
-	// Calculate balances
-	totalAmount := decimal.Zero
+	balanceSlice := make([]UserBalance, 0, len(balances))
+	for _, balance := range balances {
+		balanceSlice = append(balanceSlice, *balance)
+	}
+
+	// Sort by user ID for consistent output
+	sort.Slice(balanceSlice, func(i, j int) bool {
+		return balanceSlice[i].UserID < balanceSlice[j].UserID
+	})
+
+	result := &GroupBalancesResult{
+		GroupID:  groupID,
+		Currency: group.Currency,
+		Balances: balanceSlice,
+	}
+
+	constraints, err := s.preferenceService.paymentConstraints(groupID)
+	if err != nil {
+		return nil, err
+	}
+
+	// The balances stand on their own, so a plan the preferences rule out doesn't hide them
+	transactions, _, err := s.optimizeTransactions(balances, "", constraints)
+	if err != nil {
+		result.PlanError = err.Error()
+		return result, nil
+	}
+	for i := range transactions {
+		transactions[i].Currency = group.Currency
+	}
+	result.Transactions = transactions
+
+	return result, nil
+}
+
+// checkBillsSettleable verifies every bill belongs to the group, is finalized and is not part of
+// another non-cancelled settlement. With lock set, the bill rows stay locked until the surrounding
+// transaction ends.
+func (s *SettlementService) checkBillsSettleable(db *gorm.DB, groupID uint, billIDs []uint, lock bool) error {
+	query := db.Select("id", "status").Where("id IN ? AND group_id = ?", billIDs, groupID).Order("id")
+	if lock {
+		query = query.Clauses(clause.Locking{Strength: "UPDATE"})
+	}
+
+	var bills []models.Bill
+	if err := query.Find(&bills).Error; err != nil {
+		return fmt.Errorf("failed to check bill statuses: %w", err)
+	}
+
+	conflict := &BillConflictError{MissingBillIDs: missingBillIDs(billIDs, bills)}
 	for _, bill := range bills {
-		// Add to paid amount for the payer
-		if balance, exists := balances[bill.PaidByID]; exists {
-			balance.Paid = balance.Paid.Add(bill.TotalAmount)
+		if bill.Status != "finalized" {
+			conflict.NotFinalizedBillIDs = append(conflict.NotFinalizedBillIDs, bill.ID)
+		}
+	}
+
+	if err := db.Model(&models.SettlementBill{}).
+		Distinct("settlement_bills.bill_id").
+		Joins("JOIN settlements ON settlements.id = settlement_bills.settlement_id").
+		Where("settlement_bills.bill_id IN ? AND settlements.deleted_at IS NULL AND settlements.status NOT IN ?", billIDs, []string{"cancelled", "voided"}).
+		Order("settlement_bills.bill_id").
+		Pluck("settlement_bills.bill_id", &conflict.InSettlementBillIDs).Error; err != nil {
+		return fmt.Errorf("failed to check existing settlements: %w", err)
+	}
+
+	if len(conflict.MissingBillIDs) > 0 || len(conflict.NotFinalizedBillIDs) > 0 || len(conflict.InSettlementBillIDs) > 0 {
+		return conflict
+	}
+	return nil
+}
+
+// missingBillIDs returns the requested bill IDs that weren't found, in ascending order
+func missingBillIDs(requested []uint, found []models.Bill) []uint {
+	foundIDs := make(map[uint]bool, len(found))
+	for _, bill := range found {
+		foundIDs[bill.ID] = true
+	}
+
+	var missing []uint
+	for _, billID := range requested {
+		if !foundIDs[billID] && !slices.Contains(missing, billID) {
+			missing = append(missing, billID)
+		}
+	}
+	slices.Sort(missing)
+	return missing
+}
+
+// owesShare is one raw contribution to what a user owes for a bill. Raw shares are in the bill's
+// own currency and only their proportions matter, since they are scaled to the bill total.
+type owesShare struct {
+	userID uint
+	line   OwesLine // Amount holds the raw share
+}
+
+// calculateBillOwes calculates what each person owes for a specific bill
+func calculateBillOwes(bill *models.Bill, balances map[uint]*UserBalance, members []models.GroupMember) {
+	owes, _ := billOwes(bill, members)
+	for userID, amount := range owes {
+		if balance, exists := balances[userID]; exists {
+			balance.Owes = balance.Owes.Add(amount)
+		}
+	}
+}
+
+// billOwes returns what each person owes for a bill along with the raw shares it was worked out from
+func billOwes(bill *models.Bill, members []models.GroupMember) (map[uint]decimal.Decimal, []owesShare) {
+	shares := billOwesShares(bill, members)
+
+	// Round to the minor unit so the owed amounts add up to the bill total exactly
+	owes := allocateByWeight(bill.TotalAmount.Round(minorUnitPlaces), sumOwesShares(shares), minorUnitPlaces)
+	return owes, shares
+}
+
+// billOwesShares lists the share of every item and adjustment that each person takes on
+func billOwesShares(bill *models.Bill, members []models.GroupMember) []owesShare {
+	var shares []owesShare
+
+	// Only people who belonged to the group on the bill date share in it. A bill dated before anyone
+	// was a member is left to the people named on it rather than whoever belongs to the group now.
+	members = membersOn(members, bill.BillDate)
+	if len(members) == 0 {
+		members = billParticipants(bill)
+	}
+
+	// Shared items without owners are divided among all active members, as long as they add up to something
+	sharedTotal := decimal.Zero
+	for _, item := range bill.Items {
+		if item.IsShared && len(item.ItemOwners) == 0 {
+			sharedTotal = sharedTotal.Add(lineTotal(item.Amount, item.Quantity))
+		}
+	}
+	memberCount := decimal.NewFromInt(int64(len(members)))
+
+	for _, item := range bill.Items {
+		itemTotal := lineTotal(item.Amount, item.Quantity)
+		line := OwesLine{BillID: bill.ID, BillTitle: bill.Title, ItemID: &item.ID, Name: item.Name, Kind: "item"}
+
+		if item.IsShared && len(item.ItemOwners) == 0 {
+			if len(members) == 0 || !sharedTotal.IsPositive() {
+				continue
+			}
+			line.SplitRule = "shared"
+			line.Share = shareOf(decimal.NewFromInt(1), memberCount)
+			line.Amount = itemTotal.Div(memberCount)
+			for _, member := range members {
+				shares = append(shares, owesShare{userID: member.UserID, line: line})
+			}
+			continue
+		}
+
+		// Personal item, or shared among a chosen subset of members
+		line.SplitRule = item.SplitMode
+		if line.SplitRule == "" {
+			line.SplitRule = "equal"
+		}
+		for userID, share := range splitItem(&item, itemTotal) {
+			line.Share = shareOf(share, itemTotal)
+			line.Amount = share
+			shares = append(shares, owesShare{userID: userID, line: line})
+		}
+	}
+
+	// Spread tax, tips, fees and discounts over the people who had items
+	shares = append(shares, adjustmentShares(bill, sumOwesShares(shares), members)...)
+
+	// A bill without any assignable items is split equally among members
+	if len(shares) == 0 && len(members) > 0 {
+		line := OwesLine{
+			BillID:    bill.ID,
+			BillTitle: bill.Title,
+			Kind:      "bill",
+			SplitRule: "equal",
+			Share:     shareOf(decimal.NewFromInt(1), memberCount),
+			Amount:    bill.TotalAmount.Div(memberCount),
+		}
+		for _, member := range members {
+			shares = append(shares, owesShare{userID: member.UserID, line: line})
+		}
+	}
+
+	return shares
+}
+
+// membersOn returns the members who belonged to the group on the given day, counting both the day
+// they joined and the day they left
+func membersOn(members []models.GroupMember, date time.Time) []models.GroupMember {
+	day := calendarDay(date)
+
+	var active []models.GroupMember
+	seen := make(map[uint]bool)
+	for _, member := range members {
+		if seen[member.UserID] || calendarDay(member.JoinedAt).After(day) {
+			continue
+		}
+		if member.LeftAt != nil && calendarDay(*member.LeftAt).Before(day) {
+			continue
+		}
+		seen[member.UserID] = true
+		active = append(active, member)
+	}
+
+	return active
+}
+
+// billParticipants returns the payer and the owners of the bill's items, in the order they appear
+func billParticipants(bill *models.Bill) []models.GroupMember {
+	participants := []models.GroupMember{{GroupID: bill.GroupID, UserID: bill.PaidByID}}
+	seen := map[uint]bool{bill.PaidByID: true}
+	for _, item := range bill.Items {
+		for _, owner := range item.ItemOwners {
+			if !seen[owner.UserID] {
+				seen[owner.UserID] = true
+				participants = append(participants, models.GroupMember{GroupID: bill.GroupID, UserID: owner.UserID})
+			}
+		}
+	}
+	return participants
+}
+
+// calendarDay drops the time of day so dates compare by day
+func calendarDay(t time.Time) time.Time {
+	year, month, day := t.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+// adjustmentShares splits each bill adjustment in proportion to each person's item subtotal,
+// or equally among participants when the adjustment asks for it
+func adjustmentShares(bill *models.Bill, rawShares map[uint]decimal.Decimal, members []models.GroupMember) []owesShare {
+	if len(bill.Adjustments) == 0 {
+		return nil
+	}
+
+	// Snapshot item subtotals so one adjustment doesn't skew the next
+	subtotals := make(map[uint]decimal.Decimal)
+	participantIDs := make([]uint, 0, len(rawShares))
+	for userID, share := range rawShares {
+		if share.IsPositive() {
+			subtotals[userID] = share
+			participantIDs = append(participantIDs, userID)
+		}
+	}
+
+	// Without item subtotals, adjustments fall on every member equally
+	if len(participantIDs) == 0 {
+		for _, member := range members {
+			subtotals[member.UserID] = decimal.NewFromInt(1)
+			participantIDs = append(participantIDs, member.UserID)
+		}
+	}
+
+	var shares []owesShare
+	for _, adjustment := range bill.Adjustments {
+		amount := adjustment.SignedAmount()
+		line := OwesLine{BillID: bill.ID, BillTitle: bill.Title, Name: adjustment.Name, Kind: "adjustment"}
+		if line.Name == "" {
+			line.Name = adjustment.Type
+		}
+
+		var split map[uint]decimal.Decimal
+		if adjustment.SplitEqually {
+			line.SplitRule = "equal"
+			split = splitEqually(amount, participantIDs)
+		} else {
+			line.SplitRule = "proportional"
+			split = splitProportionally(amount, subtotals)
+		}
+
+		for userID, share := range split {
+			line.Share = shareOf(share, amount)
+			line.Amount = share
+			shares = append(shares, owesShare{userID: userID, line: line})
+		}
+	}
+	return shares
+}
+
+// sumOwesShares totals the raw shares per person
+func sumOwesShares(shares []owesShare) map[uint]decimal.Decimal {
+	totals := make(map[uint]decimal.Decimal)
+	for _, share := range shares {
+		totals[share.userID] = totals[share.userID].Add(share.line.Amount)
+	}
+	return totals
+}
+
+// shareOf returns part as a fraction of whole, for display
+func shareOf(part, whole decimal.Decimal) decimal.Decimal {
+	if whole.IsZero() {
+		return decimal.Zero
+	}
+	return part.Div(whole).Round(4)
+}
+
+// explainBillOwes breaks what each person owes for a bill into lines that add up to it exactly.
+// Each raw share is scaled to the bill total, which also converts it into the settlement currency,
+// and rounded to the minor unit; a rounding line makes up whatever the rounding left over.
+func explainBillOwes(bill *models.Bill, members []models.GroupMember) map[uint][]OwesLine {
+	owes, shares := billOwes(bill, members)
+
+	rawTotal := decimal.Zero
+	for _, share := range shares {
+		rawTotal = rawTotal.Add(share.line.Amount)
+	}
+	billTotal := bill.TotalAmount.Round(minorUnitPlaces)
+
+	lines := make(map[uint][]OwesLine)
+	explained := make(map[uint]decimal.Decimal)
+	for _, share := range shares {
+		line := share.line
+		line.Amount = decimal.Zero
+		if !rawTotal.IsZero() {
+			line.Amount = share.line.Amount.Mul(billTotal).Div(rawTotal).Round(minorUnitPlaces)
+		}
+		lines[share.userID] = append(lines[share.userID], line)
+		explained[share.userID] = explained[share.userID].Add(line.Amount)
+	}
+
+	for userID, amount := range owes {
+		if rounding := amount.Sub(explained[userID]); !rounding.IsZero() {
+			lines[userID] = append(lines[userID], OwesLine{
+				BillID:    bill.ID,
+				BillTitle: bill.Title,
+				Kind:      "rounding",
+				SplitRule: "largest_remainder",
+				Amount:    rounding,
+			})
+		}
+	}
+
+	return lines
+}
+
+// splitProportionally divides an amount in proportion to the given weights
+func splitProportionally(amount decimal.Decimal, weights map[uint]decimal.Decimal) map[uint]decimal.Decimal {
+	shares := make(map[uint]decimal.Decimal, len(weights))
+
+	totalWeight := decimal.Zero
+	for _, weight := range weights {
+		totalWeight = totalWeight.Add(weight)
+	}
+	if totalWeight.IsZero() {
+		return shares
+	}
+
+	for userID, weight := range weights {
+		shares[userID] = amount.Mul(weight).Div(totalWeight)
+	}
+	return shares
+}
+
+// allocateByWeight splits total into minor-unit amounts proportional to the given weights.
+// Each share is rounded down first; the leftover units go to the largest remainders,
+// with ties broken by the lowest user ID, so the shares always sum to total exactly.
+func allocateByWeight(total decimal.Decimal, weights map[uint]decimal.Decimal, places int32) map[uint]decimal.Decimal {
+	allocation := make(map[uint]decimal.Decimal, len(weights))
+
+	totalWeight := decimal.Zero
+	userIDs := make([]uint, 0, len(weights))
+	for userID, weight := range weights {
+		totalWeight = totalWeight.Add(weight)
+		userIDs = append(userIDs, userID)
+	}
+	if totalWeight.IsZero() {
+		return allocation
+	}
+	sort.Slice(userIDs, func(i, j int) bool { return userIDs[i] < userIDs[j] })
+
+	allocated := decimal.Zero
+	remainders := make(map[uint]decimal.Decimal, len(weights))
+	for _, userID := range userIDs {
+		exact := total.Mul(weights[userID]).Div(totalWeight)
+		share := exact.RoundFloor(places)
+		allocation[userID] = share
+		remainders[userID] = exact.Sub(share)
+		allocated = allocated.Add(share)
+	}
+
+	// Hand out the leftover minor units by largest remainder
+	unit := decimal.New(1, -places)
+	leftover := total.Sub(allocated).Div(unit).IntPart()
+	sort.SliceStable(userIDs, func(i, j int) bool {
+		return remainders[userIDs[i]].GreaterThan(remainders[userIDs[j]])
+	})
+	for i := int64(0); i < leftover; i++ {
+		userID := userIDs[i%int64(len(userIDs))]
+		allocation[userID] = allocation[userID].Add(unit)
+	}
+
+	return allocation
+}
+
+// splitItem divides an item total among its owners according to the item's split mode
+func splitItem(item *models.BillItem, itemTotal decimal.Decimal) map[uint]decimal.Decimal {
+	switch item.SplitMode {
+	case "shares", "percent":
+		// Percentages are ratios that happen to sum to 100
+		return splitByShareRatio(itemTotal, item.ItemOwners)
+	case "exact":
+		shares := make(map[uint]decimal.Decimal)
+		for _, owner := range item.ItemOwners {
+			shares[owner.UserID] = shares[owner.UserID].Add(owner.Amount)
+		}
+		return shares
+	default:
+		userIDs := make([]uint, 0, len(item.ItemOwners))
+		for _, owner := range item.ItemOwners {
+			userIDs = append(userIDs, owner.UserID)
+		}
+		return splitEqually(itemTotal, userIDs)
+	}
+}
+
+// splitEqually divides an amount equally among the given users
+func splitEqually(amount decimal.Decimal, userIDs []uint) map[uint]decimal.Decimal {
+	shares := make(map[uint]decimal.Decimal)
+	if len(userIDs) == 0 {
+		return shares
+	}
+
+	sharePerUser := amount.Div(decimal.NewFromInt(int64(len(userIDs))))
+	for _, userID := range userIDs {
+		shares[userID] = shares[userID].Add(sharePerUser)
+	}
+	return shares
+}
+
+// splitByShareRatio divides an amount among item owners in proportion to their share ratios
+func splitByShareRatio(amount decimal.Decimal, owners []models.ItemOwner) map[uint]decimal.Decimal {
+	shares := make(map[uint]decimal.Decimal)
+	if len(owners) == 0 {
+		return shares
+	}
+
+	totalRatio := decimal.Zero
+	for _, owner := range owners {
+		totalRatio = totalRatio.Add(owner.ShareRatio)
+	}
+
+	for _, owner := range owners {
+		// Fall back to an equal split when no positive ratios are stored
+		if !totalRatio.IsPositive() {
+			shares[owner.UserID] = shares[owner.UserID].Add(amount.Div(decimal.NewFromInt(int64(len(owners)))))
+			continue
+		}
+		shares[owner.UserID] = shares[owner.UserID].Add(amount.Mul(owner.ShareRatio).Div(totalRatio))
+	}
+
+	return shares
+}
+
+// optimizeTransactions turns balances into payments and reports the strategy it used.
+// The minimal strategy finds the fewest payments exactly but falls back to greedy for large groups.
+// When payment preferences involve anyone who has to pay, they take precedence over either strategy.
+func (s *SettlementService) optimizeTransactions(balances map[uint]*UserBalance, strategy string, constraints paymentConstraints) ([]Transaction, string, error) {
+	var outstanding []*UserBalance
+	for _, balance := range balances {
+		if !balance.Balance.IsZero() {
+			outstanding = append(outstanding, balance)
+		}
+	}
+	sort.Slice(outstanding, func(i, j int) bool {
+		return outstanding[i].UserID < outstanding[j].UserID
+	})
+
+	if constraints.appliesTo(outstanding) {
+		transactions, err := constrainedTransactions(outstanding, constraints)
+		return transactions, "constrained", err
+	}
+
+	maxMembers := 0
+	if s.config != nil {
+		maxMembers = min(s.config.ExactSolverMaxMembers, maxExactSolverMembers)
+	}
+	if strategy != "greedy" && len(outstanding) <= maxMembers {
+		if transactions, ok := minimalTransactions(outstanding); ok {
+			return transactions, "minimal", nil
+		}
+	}
+	return greedyTransactions(outstanding), "greedy", nil
+}
+
+// constrainedTransactions plans payments that never use a blocked pair and route as much as
+// possible through preferred pairs. It solves a min-cost flow from debtors to creditors in
+// which preferred pairs are free, other pairs cost one per cent and blocked pairs are absent.
+func constrainedTransactions(outstanding []*UserBalance, constraints paymentConstraints) ([]Transaction, error) {
+	var debtors, creditors []*UserBalance
+	for _, balance := range outstanding {
+		if balance.Balance.IsNegative() {
+			debtors = append(debtors, balance)
+		} else if balance.Balance.IsPositive() {
+			creditors = append(creditors, balance)
+		}
+	}
+
+	// Node 0 is the source, then debtors, then creditors, then the sink
+	source, sink := 0, len(debtors)+len(creditors)+1
+	graph := newFlowGraph(sink + 1)
+
+	var totalDebt, totalCredit int64
+	debts := make([]int64, len(debtors))
+	for i, debtor := range debtors {
+		debts[i] = debtor.Balance.Neg().Shift(minorUnitPlaces).IntPart()
+		totalDebt += debts[i]
+		graph.addEdge(source, 1+i, debts[i], 0)
+	}
+	credits := make([]int64, len(creditors))
+	for j, creditor := range creditors {
+		credits[j] = creditor.Balance.Shift(minorUnitPlaces).IntPart()
+		totalCredit += credits[j]
+		graph.addEdge(1+len(debtors)+j, sink, credits[j], 0)
+	}
+
+	type pairEdge struct {
+		debtor, creditor, edge int
+	}
+	var pairs []pairEdge
+	for i, debtor := range debtors {
+		for j, creditor := range creditors {
+			kind := constraints[debtor.UserID][creditor.UserID]
+			if kind == "block" {
+				continue
+			}
+			var cost int64 = 1
+			if kind == "prefer" {
+				cost = 0
+			}
+			edge := graph.addEdge(1+i, 1+len(debtors)+j, min(debts[i], credits[j]), cost)
+			pairs = append(pairs, pairEdge{debtor: i, creditor: j, edge: edge})
 		}
-		totalAmount = totalAmount.Add(bill.TotalAmount)
-
-		// Calculate what each person owes for this bill
-		s.calculateBillOwes(&bill, balances, members)
 	}
 
-	// Calculate final balances (positive = should receive, negative = should pay)
-	for _, balance := range balances {
-		balance.Balance = balance.Paid.Sub(balance.Owes)
+	if graph.minCostMaxFlow(source, sink) < min(totalDebt, totalCredit) {
+		return nil, errors.New("payment preferences make settlement impossible")
 	}
 
-	// Convert map to slice for output
-	balanceSlice := make([]UserBalance, 0, len(balances))
-	for _, balance := range balances {
-		balanceSlice = append(balanceSlice, *balance)
+	var transactions []Transaction
+	for _, pair := range pairs {
+		flow := graph.edges[pair.edge].flow
+		if flow <= 0 {
+			continue
+		}
+		transactions = append(transactions, Transaction{
+			FromUserID:   debtors[pair.debtor].UserID,
+			FromUserName: debtors[pair.debtor].UserName,
+			ToUserID:     creditors[pair.creditor].UserID,
+			ToUserName:   creditors[pair.creditor].UserName,
+			Amount:       decimal.New(flow, -minorUnitPlaces),
+		})
 	}
+	return transactions, nil
+}
 
-	// Sort by user ID for consistent output
-	sort.Slice(balanceSlice, func(i, j int) bool {
-		return balanceSlice[i].UserID < balanceSlice[j].UserID
-	})
-
-	// Calculate optimal transactions
-	transactions := s.optimizeTransactions(balances)
+// flowEdge is a directed edge in a flowGraph; its reverse edge sits at the index with the last bit flipped
+type flowEdge struct {
+	to       int
+	capacity int64
+	cost     int64
+	flow     int64
+}
 
-	return &SettlementResult{
-		GroupID:      req.GroupID,
-		BillCount:    len(bills),
-		TotalAmount:  totalAmount,
-		Balances:     balanceSlice,
-		Transactions: transactions,
-	}, nil
+// flowGraph is a small residual graph for min-cost flow over integer amounts
+type flowGraph struct {
+	edges []flowEdge
+	adj   [][]int
 }
 
-// calculateBillOwes calculates what each person owes for a specific bill
-func (s *SettlementService) calculateBillOwes(bill *models.Bill, balances map[uint]*UserBalance, members []models.GroupMember) {
-	// Separate shared and personal items
-	var sharedTotal decimal.Decimal
-	personalTotals := make(map[uint]decimal.Decimal)
+// newFlowGraph creates an empty graph with the given number of nodes
+func newFlowGraph(nodes int) *flowGraph {
+	return &flowGraph{adj: make([][]int, nodes)}
+}
 
-	for _, item := range bill.Items {
-		itemTotal := item.Amount.Mul(decimal.NewFromInt(int64(item.Quantity)))
+// addEdge adds an edge and its zero-capacity reverse edge, returning the forward edge's index
+func (g *flowGraph) addEdge(from, to int, capacity, cost int64) int {
+	g.edges = append(g.edges, flowEdge{to: to, capacity: capacity, cost: cost})
+	g.adj[from] = append(g.adj[from], len(g.edges)-1)
+	g.edges = append(g.edges, flowEdge{to: from, cost: -cost})
+	g.adj[to] = append(g.adj[to], len(g.edges)-1)
+	return len(g.edges) - 2
+}
 
-		if item.IsShared {
-			sharedTotal = sharedTotal.Add(itemTotal)
-		} else {
-			// Personal item - divide among owners
-			if len(item.Owners) > 0 {
-				sharePerOwner := itemTotal.Div(decimal.NewFromInt(int64(len(item.Owners))))
-				for _, owner := range item.Owners {
-					current := personalTotals[owner.ID]
-					personalTotals[owner.ID] = current.Add(sharePerOwner)
+// minCostMaxFlow pushes as much flow as possible from source to sink along successively
+// cheapest paths and returns the total flow
+func (g *flowGraph) minCostMaxFlow(source, sink int) int64 {
+	var total int64
+	for {
+		// Bellman-Ford, since reverse edges carry negative costs
+		dist := make([]int64, len(g.adj))
+		prev := make([]int, len(g.adj))
+		for i := range dist {
+			dist[i] = math.MaxInt64
+			prev[i] = -1
+		}
+		dist[source] = 0
+		for updated := true; updated; {
+			updated = false
+			for node := range g.adj {
+				if dist[node] == math.MaxInt64 {
+					continue
+				}
+				for _, idx := range g.adj[node] {
+					edge := g.edges[idx]
+					if edge.capacity-edge.flow > 0 && dist[node]+edge.cost < dist[edge.to] {
+						dist[edge.to] = dist[node] + edge.cost
+						prev[edge.to] = idx
+						updated = true
+					}
 				}
 			}
 		}
+		if prev[sink] == -1 {
+			return total
+		}
+
+		// Push the bottleneck amount along the path
+		push := int64(math.MaxInt64)
+		for node := sink; node != source; node = g.edges[prev[node]^1].to {
+			edge := g.edges[prev[node]]
+			push = min(push, edge.capacity-edge.flow)
+		}
+		for node := sink; node != source; node = g.edges[prev[node]^1].to {
+			g.edges[prev[node]].flow += push
+			g.edges[prev[node]^1].flow -= push
+		}
+		total += push
 	}
+}
 
-	// Divide shared total among all active members
-	activeMemberCount := len(members)
-	if activeMemberCount > 0 && sharedTotal.GreaterThan(decimal.Zero) {
-		sharePerMember := sharedTotal.Div(decimal.NewFromInt(int64(activeMemberCount)))
+// minimalTransactions settles the balances with the fewest payments. Every subset of balances that
+// sums to zero can be settled on its own with one payment fewer than its size, so the fewest
+// payments come from splitting the members into as many zero-sum subsets as possible.
+// It reports false when the balances don't sum to zero.
+func minimalTransactions(outstanding []*UserBalance) ([]Transaction, bool) {
+	n := len(outstanding)
+	cents := make([]int64, n)
+	for i, balance := range outstanding {
+		cents[i] = balance.Balance.Shift(minorUnitPlaces).IntPart()
+	}
 
-		// Add shared amount to each member's owes
-		for _, member := range members {
-			if balance, exists := balances[member.UserID]; exists {
-				balance.Owes = balance.Owes.Add(sharePerMember)
+	// sums[mask] is the total of the balances in mask; groups[mask] is the most zero-sum
+	// subsets that mask can be split into, counting mask itself when it sums to zero
+	size := 1 << n
+	sums := make([]int64, size)
+	groups := make([]int8, size)
+	for mask := 1; mask < size; mask++ {
+		low := bits.TrailingZeros(uint(mask))
+		sums[mask] = sums[mask&^(1<<low)] + cents[low]
+
+		var best int8
+		for i := 0; i < n; i++ {
+			if mask&(1<<i) != 0 && groups[mask&^(1<<i)] > best {
+				best = groups[mask&^(1<<i)]
 			}
 		}
+		if sums[mask] == 0 {
+			best++
+		}
+		groups[mask] = best
 	}
 
-	// Add personal amounts to specific users' owes
-	for userID, amount := range personalTotals {
-		if balance, exists := balances[userID]; exists {
-			balance.Owes = balance.Owes.Add(amount)
+	full := size - 1
+	if sums[full] != 0 {
+		return nil, false
+	}
+
+	// Walk back from the full set one member at a time; the members removed between two
+	// zero-sum masks form one zero-sum subset, which greedy settles in size-1 payments
+	var transactions []Transaction
+	var subset []*UserBalance
+	for mask := full; mask != 0; {
+		target := groups[mask]
+		if sums[mask] == 0 {
+			target--
+		}
+		for i := 0; i < n; i++ {
+			if mask&(1<<i) != 0 && groups[mask&^(1<<i)] == target {
+				subset = append(subset, outstanding[i])
+				mask &^= 1 << i
+				break
+			}
+		}
+		if sums[mask] == 0 {
+			transactions = append(transactions, greedyTransactions(subset)...)
+			subset = nil
 		}
 	}
+
+	return transactions, true
 }
 
-// optimizeTransactions calculates the minimum number of transactions needed
-func (s *SettlementService) optimizeTransactions(balances map[uint]*UserBalance) []Transaction {
+// greedyTransactions repeatedly matches the largest debtor with the largest creditor
+func greedyTransactions(outstanding []*UserBalance) []Transaction {
 	// Separate creditors (positive balance) and debtors (negative balance)
 	type balanceInfo struct {
 		userID   uint
@@ -206,7 +1171,7 @@ func (s *SettlementService) optimizeTransactions(balances map[uint]*UserBalance)
 	var creditors []balanceInfo
 	var debtors []balanceInfo
 
-	for _, balance := range balances {
+	for _, balance := range outstanding {
 		if balance.Balance.GreaterThan(decimal.Zero) {
 			creditors = append(creditors, balanceInfo{
 				userID:   balance.UserID,
@@ -224,10 +1189,16 @@ func (s *SettlementService) optimizeTransactions(balances map[uint]*UserBalance)
 
 	// Sort for consistent results
 	sort.Slice(creditors, func(i, j int) bool {
-		return creditors[i].amount.GreaterThan(creditors[j].amount)
+		if !creditors[i].amount.Equal(creditors[j].amount) {
+			return creditors[i].amount.GreaterThan(creditors[j].amount)
+		}
+		return creditors[i].userID < creditors[j].userID
 	})
 	sort.Slice(debtors, func(i, j int) bool {
-		return debtors[i].amount.GreaterThan(debtors[j].amount)
+		if !debtors[i].amount.Equal(debtors[j].amount) {
+			return debtors[i].amount.GreaterThan(debtors[j].amount)
+		}
+		return debtors[i].userID < debtors[j].userID
 	})
 
 	var transactions []Transaction
@@ -244,8 +1215,8 @@ func (s *SettlementService) optimizeTransactions(balances map[uint]*UserBalance)
 			transactionAmount = creditor.amount
 		}
 
-		// Skip very small amounts (less than 1 cent)
-		if transactionAmount.GreaterThan(decimal.NewFromFloat(0.01)) {
+		// Balances are exact to the minor unit, so every positive amount is a real payment
+		if transactionAmount.IsPositive() {
 			transactions = append(transactions, Transaction{
 				FromUserID:   debtor.userID,
 				FromUserName: debtor.userName,
@@ -260,10 +1231,10 @@ func (s *SettlementService) optimizeTransactions(balances map[uint]*UserBalance)
 		creditor.amount = creditor.amount.Sub(transactionAmount)
 
 		// Move to next debtor/creditor if current one is settled
-		if debtor.amount.LessThanOrEqual(decimal.NewFromFloat(0.01)) {
+		if !debtor.amount.IsPositive() {
 			i++
 		}
-		if creditor.amount.LessThanOrEqual(decimal.NewFromFloat(0.01)) {
+		if !creditor.amount.IsPositive() {
 			j++
 		}
 	}
@@ -284,19 +1255,26 @@ func (s *SettlementService) CreateSettlement(userID uint, req CalculateSettlemen
 	// Create settlement record
 	settlement := models.Settlement{
 		GroupID:     req.GroupID,
-		Title:       fmt.Sprintf("Settlement for %d bills", len(req.BillIDs)),
+		Title:       fmt.Sprintf("Settlement for %d bills", len(result.BillIDs)),
 		Description: fmt.Sprintf("Total amount: %s", result.TotalAmount.String()),
+		Currency:    result.Currency,
 		CreatedByID: userID,
 		Status:      "pending",
 	}
 
+	// Re-check under lock so concurrent settlements can't claim the same bills
+	if err := s.checkBillsSettleable(tx, req.GroupID, result.BillIDs, true); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
 	if err := tx.Create(&settlement).Error; err != nil {
 		tx.Rollback()
 		return nil, fmt.Errorf("failed to create settlement: %w", err)
 	}
 
 	// Link bills to settlement
-	for _, billID := range req.BillIDs {
+	for _, billID := range result.BillIDs {
 		settlementBill := models.SettlementBill{
 			SettlementID: settlement.ID,
 			BillID:       billID,
@@ -307,6 +1285,21 @@ func (s *SettlementService) CreateSettlement(userID uint, req CalculateSettlemen
 		}
 	}
 
+	// Keep the balances as calculated now, so statements don't change with later rates or preferences
+	for _, balance := range result.Balances {
+		settlementBalance := models.SettlementBalance{
+			SettlementID: settlement.ID,
+			UserID:       balance.UserID,
+			Paid:         balance.Paid,
+			Owes:         balance.Owes,
+			Balance:      balance.Balance,
+		}
+		if err := tx.Create(&settlementBalance).Error; err != nil {
+			tx.Rollback()
+			return nil, fmt.Errorf("failed to store balance: %w", err)
+		}
+	}
+
 	// Create settlement transactions
 	for _, trans := range result.Transactions {
 		transaction := models.SettlementTransaction{
@@ -314,6 +1307,7 @@ func (s *SettlementService) CreateSettlement(userID uint, req CalculateSettlemen
 			FromUserID:   trans.FromUserID,
 			ToUserID:     trans.ToUserID,
 			Amount:       trans.Amount,
+			Currency:     result.Currency,
 			Status:       "pending",
 		}
 		if err := tx.Create(&transaction).Error; err != nil {
@@ -356,13 +1350,18 @@ func (s *SettlementService) ConfirmSettlement(settlementID, userID uint) error {
 	// Start transaction
 	tx := s.db.Begin()
 
-	// Update settlement status
+	// Update settlement status, unless it was cancelled in the meantime
 	now := time.Now()
-	settlement.Status = "confirmed"
-	settlement.SettledAt = &now
-	if err := tx.Save(&settlement).Error; err != nil {
+	result := tx.Model(&models.Settlement{}).
+		Where("id = ? AND status = ?", settlementID, "pending").
+		Updates(map[string]interface{}{"status": "confirmed", "settled_at": now})
+	if result.Error != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to update settlement: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
 		tx.Rollback()
-		return fmt.Errorf("failed to update settlement: %w", err)
+		return errors.New("settlement is not pending")
 	}
 
 	// Update all related bills to settled
@@ -382,6 +1381,410 @@ func (s *SettlementService) ConfirmSettlement(settlementID, userID uint) error {
 	return nil
 }
 
+// CancelSettlement cancels a pending settlement and releases its bills
+func (s *SettlementService) CancelSettlement(settlementID, userID uint, req CancelSettlementRequest) error {
+	return s.revokeSettlement(settlementID, userID, "cancel", "pending", "cancelled", req.Reason)
+}
+
+// VoidSettlement voids a confirmed settlement, reverses any payments recorded against it in the
+// running balances and rolls its bills back to finalized
+func (s *SettlementService) VoidSettlement(settlementID, userID uint, req CancelSettlementRequest) error {
+	return s.revokeSettlement(settlementID, userID, "void", "confirmed", "voided", req.Reason)
+}
+
+// revokeSettlement moves a settlement from fromStatus to toStatus, cancels its transactions,
+// reverses any payments recorded against them and resets its bills to finalized in a single DB
+// transaction. action names what is being done in error messages.
+func (s *SettlementService) revokeSettlement(settlementID, userID uint, action, fromStatus, toStatus, reason string) error {
+	var settlement models.Settlement
+	if err := s.db.First(&settlement, settlementID).Error; err != nil {
+		return errors.New("settlement not found")
+	}
+
+	// Verify user has permission (creator or group admin)
+	if settlement.CreatedByID != userID && !s.groupService.IsUserAdmin(settlement.GroupID, userID) {
+		return fmt.Errorf("only settlement creator or group admin can %s settlement", action)
+	}
+
+	// Start transaction
+	tx := s.db.Begin()
+
+	// Change the status only if nobody confirmed or revoked the settlement in the meantime.
+	// This locks the settlement row, so no payment can be recorded until the transaction ends.
+	now := time.Now()
+	result := tx.Model(&models.Settlement{}).
+		Where("id = ? AND status = ?", settlementID, fromStatus).
+		Updates(map[string]interface{}{
+			"status":          toStatus,
+			"cancelled_at":    now,
+			"cancelled_by_id": userID,
+			"cancel_reason":   reason,
+		})
+	if result.Error != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to update settlement: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		tx.Rollback()
+		return fmt.Errorf("settlement is not %s", fromStatus)
+	}
+
+	// Payments made towards a revoked settlement no longer count, so take them back out of the
+	// running balances; they stay on the transactions as a record of what happened
+	var paid []models.SettlementTransaction
+	if err := tx.
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("settlement_id = ? AND (paid_amount > 0 OR status = ?)", settlementID, "paid").
+		Find(&paid).Error; err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to check payments: %w", err)
+	}
+	for _, transaction := range paid {
+		amount := recordedPayment(&transaction)
+		if err := s.ledgerService.recordPayment(tx, settlement.GroupID, transaction.ToUserID, transaction.FromUserID, amount); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if err := tx.Model(&models.SettlementTransaction{}).
+		Where("settlement_id = ?", settlementID).
+		Update("status", "cancelled").Error; err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to cancel transactions: %w", err)
+	}
+
+	// Release the bills so they can be settled again
+	var billIDs []uint
+	tx.Model(&models.SettlementBill{}).
+		Where("settlement_id = ?", settlementID).
+		Pluck("bill_id", &billIDs)
+
+	if err := tx.Model(&models.Bill{}).
+		Where("id IN ? AND status = ?", billIDs, "settled").
+		Update("status", "finalized").Error; err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to update bill statuses: %w", err)
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// SetPaymentCurrency lets the payer pay a transaction in another currency. The rate in effect today
+// is snapshotted so the amount to pay doesn't move with later rate updates.
+func (s *SettlementService) SetPaymentCurrency(settlementID, transactionID, userID uint, req SetPaymentCurrencyRequest) (*models.SettlementTransaction, error) {
+	// Start transaction
+	tx := s.db.Begin()
+
+	// Lock the settlement and the transaction, so no payment is recorded while the rate changes
+	var settlement models.Settlement
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&settlement, settlementID).Error; err != nil {
+		tx.Rollback()
+		return nil, errors.New("settlement not found")
+	}
+
+	if !acceptsPayments(&settlement) {
+		tx.Rollback()
+		return nil, errors.New("settlement is not open for payments")
+	}
+
+	var transaction models.SettlementTransaction
+	err := tx.
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("id = ? AND settlement_id = ?", transactionID, settlementID).
+		First(&transaction).Error
+	if err != nil {
+		tx.Rollback()
+		return nil, errors.New("transaction not found")
+	}
+
+	if transaction.FromUserID != userID {
+		tx.Rollback()
+		return nil, errors.New("only the payer can choose the payment currency")
+	}
+
+	if err := setPaymentCurrency(&transaction, req.Currency, s.exchangeRateService.rateOn); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Model(&transaction).
+		Select("payment_currency", "payment_amount", "exchange_rate", "rate_date", "updated_at").
+		Updates(&transaction).Error; err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("failed to update transaction: %w", err)
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	// Load full transaction data
+	if err := s.db.Preload("FromUser").Preload("ToUser").Preload("Payments").First(&transaction, transaction.ID).Error; err != nil {
+		return nil, fmt.Errorf("failed to load transaction data: %w", err)
+	}
+
+	return &transaction, nil
+}
+
+// setPaymentCurrency snapshots the rate in effect today for paying a transaction in currency, or
+// clears an earlier choice when currency is the settlement currency
+func setPaymentCurrency(transaction *models.SettlementTransaction, currency string, rateOn func(from, to string, date time.Time) (decimal.Decimal, time.Time, error)) error {
+	if !transaction.PaidAmount.IsZero() {
+		return errors.New("cannot change payment currency after payments are recorded")
+	}
+
+	if currency == transaction.Currency {
+		transaction.PaymentCurrency = ""
+		transaction.PaymentAmount = nil
+		transaction.ExchangeRate = nil
+		transaction.RateDate = nil
+		return nil
+	}
+
+	rate, rateDate, err := rateOn(transaction.Currency, currency, time.Now())
+	if err != nil {
+		return err
+	}
+	// Round to what the exchange_rate column stores, so the snapshot reproduces the amount
+	rate = rate.Round(8)
+	paymentAmount := transaction.Amount.Mul(rate).Round(minorUnitPlaces)
+
+	transaction.PaymentCurrency = currency
+	transaction.PaymentAmount = &paymentAmount
+	transaction.ExchangeRate = &rate
+	transaction.RateDate = &rateDate
+	return nil
+}
+
+// paymentInSettlementCurrency converts a payment made in the transaction's payment currency into
+// the settlement currency at the snapshotted rate
+func paymentInSettlementCurrency(transaction *models.SettlementTransaction, amount decimal.Decimal) decimal.Decimal {
+	if transaction.PaymentCurrency == "" || transaction.ExchangeRate == nil || !transaction.ExchangeRate.IsPositive() {
+		return amount
+	}
+
+	// Paying everything that is left clears the transaction, whichever way the conversion rounds
+	remaining := transaction.RemainingAmount
+	if amount.Equal(remaining.Mul(*transaction.ExchangeRate).Round(minorUnitPlaces)) {
+		return remaining
+	}
+	return amount.Div(*transaction.ExchangeRate).Round(minorUnitPlaces)
+}
+
+// RecordPayment records a full or partial payment by the payer of a transaction in a confirmed settlement
+func (s *SettlementService) RecordPayment(settlementID, transactionID, userID uint, req RecordPaymentRequest) (*models.SettlementTransaction, error) {
+	// Start transaction
+	tx := s.db.Begin()
+
+	// Lock the settlement and the transaction, so concurrent payments are checked one after another
+	var settlement models.Settlement
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&settlement, settlementID).Error; err != nil {
+		tx.Rollback()
+		return nil, errors.New("settlement not found")
+	}
+
+	if !acceptsPayments(&settlement) {
+		tx.Rollback()
+		return nil, errors.New("settlement is not open for payments")
+	}
+
+	var transaction models.SettlementTransaction
+	err := tx.
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("id = ? AND settlement_id = ?", transactionID, settlementID).
+		First(&transaction).Error
+	if err != nil {
+		tx.Rollback()
+		return nil, errors.New("transaction not found")
+	}
+
+	// Placeholders can't log in, so the receiver records what a placeholder paid them
+	if transaction.FromUserID != userID && !(transaction.ToUserID == userID && s.isPlaceholder(transaction.FromUserID)) {
+		tx.Rollback()
+		return nil, errors.New("only the payer can record a payment")
+	}
+
+	// Default to paying off whatever is left
+	amount := paymentInSettlementCurrency(&transaction, req.Amount)
+	if req.Amount.IsZero() {
+		amount = transaction.RemainingAmount
+	}
+	if !amount.IsPositive() {
+		tx.Rollback()
+		return nil, errors.New("payment amount must be positive")
+	}
+	if amount.GreaterThan(transaction.RemainingAmount) {
+		tx.Rollback()
+		return nil, errors.New("payment exceeds remaining amount")
+	}
+
+	now := time.Now()
+	payment := models.SettlementPayment{
+		TransactionID: transaction.ID,
+		Amount:        amount,
+		Notes:         req.Notes,
+		PaidAt:        now,
+	}
+	if err := tx.Create(&payment).Error; err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("failed to record payment: %w", err)
+	}
+
+	transaction.PaidAmount = transaction.PaidAmount.Add(amount)
+	transaction.Status = "partially_paid"
+	if transaction.PaidAmount.Equal(transaction.Amount) {
+		transaction.Status = "paid"
+		transaction.PaidAt = &now
+	}
+	if req.Notes != "" {
+		transaction.Notes = req.Notes
+	}
+	if err := tx.Save(&transaction).Error; err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("failed to update transaction: %w", err)
+	}
+
+	// Move the money between the members' running balances
+	if err := s.ledgerService.recordPayment(tx, settlement.GroupID, transaction.FromUserID, transaction.ToUserID, amount); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := s.completeSettlementIfPaid(tx, &settlement); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	// Load full transaction data
+	if err := s.db.Preload("FromUser").Preload("ToUser").Preload("Payments").First(&transaction, transaction.ID).Error; err != nil {
+		return nil, fmt.Errorf("failed to load transaction data: %w", err)
+	}
+
+	return &transaction, nil
+}
+
+// acceptsPayments checks if payments can be made towards a settlement. Only confirmed settlements
+// take payments, so paying every transaction completes a settlement everyone has agreed to.
+func acceptsPayments(settlement *models.Settlement) bool {
+	return settlement.Status == "confirmed"
+}
+
+// isPlaceholder checks if a user is a placeholder participant without an account
+func (s *SettlementService) isPlaceholder(userID uint) bool {
+	var count int64
+	s.db.Model(&models.User{}).Where("id = ? AND is_placeholder = ?", userID, true).Count(&count)
+	return count > 0
+}
+
+// AcknowledgePayment lets the receiver of a settlement transaction confirm the payments so far
+func (s *SettlementService) AcknowledgePayment(settlementID, transactionID, userID uint) (*models.SettlementTransaction, error) {
+	var settlement models.Settlement
+	if err := s.db.Select("id", "group_id", "status").First(&settlement, settlementID).Error; err != nil {
+		return nil, errors.New("settlement not found")
+	}
+
+	// The payment that completed a settlement can still be acknowledged afterwards
+	if !acceptsPayments(&settlement) && settlement.Status != "completed" {
+		return nil, errors.New("settlement is not open for payments")
+	}
+
+	var transaction models.SettlementTransaction
+	if err := s.db.Where("id = ? AND settlement_id = ?", transactionID, settlementID).First(&transaction).Error; err != nil {
+		return nil, errors.New("transaction not found")
+	}
+
+	if transaction.ToUserID != userID {
+		isAdmin := s.groupService.IsUserAdmin(settlement.GroupID, userID)
+		if !canAcknowledge(&transaction, userID, s.isPlaceholder(transaction.ToUserID), isAdmin) {
+			return nil, errors.New("only the receiver can acknowledge a payment")
+		}
+	}
+
+	if !transaction.PaidAmount.IsPositive() {
+		return nil, errors.New("no payment to acknowledge")
+	}
+
+	// Start transaction
+	tx := s.db.Begin()
+
+	now := time.Now()
+	if err := tx.Model(&models.SettlementPayment{}).
+		Where("transaction_id = ? AND acknowledged_at IS NULL", transaction.ID).
+		Update("acknowledged_at", now).Error; err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("failed to acknowledge payments: %w", err)
+	}
+
+	if err := tx.Model(&transaction).Update("acknowledged_at", now).Error; err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("failed to acknowledge transaction: %w", err)
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	// Load full transaction data
+	if err := s.db.Preload("FromUser").Preload("ToUser").Preload("Payments").First(&transaction, transaction.ID).Error; err != nil {
+		return nil, fmt.Errorf("failed to load transaction data: %w", err)
+	}
+
+	return &transaction, nil
+}
+
+// canAcknowledge checks if a user may acknowledge the payments on a transaction: its receiver, or
+// the payer or a group admin when the receiver is a placeholder who can't log in
+func canAcknowledge(transaction *models.SettlementTransaction, userID uint, receiverIsPlaceholder, isAdmin bool) bool {
+	if transaction.ToUserID == userID {
+		return true
+	}
+	return receiverIsPlaceholder && (transaction.FromUserID == userID || isAdmin)
+}
+
+// completeSettlementIfPaid marks a settlement completed, and its bills settled, once every transaction is fully paid
+func (s *SettlementService) completeSettlementIfPaid(tx *gorm.DB, settlement *models.Settlement) error {
+	var openCount int64
+	if err := tx.Model(&models.SettlementTransaction{}).
+		Where("settlement_id = ? AND status <> ?", settlement.ID, "paid").
+		Count(&openCount).Error; err != nil {
+		return fmt.Errorf("failed to check settlement transactions: %w", err)
+	}
+	if openCount > 0 {
+		return nil
+	}
+
+	now := time.Now()
+	settlement.Status = "completed"
+	if settlement.SettledAt == nil {
+		settlement.SettledAt = &now
+	}
+	if err := tx.Save(settlement).Error; err != nil {
+		return fmt.Errorf("failed to complete settlement: %w", err)
+	}
+
+	var billIDs []uint
+	tx.Model(&models.SettlementBill{}).
+		Where("settlement_id = ?", settlement.ID).
+		Pluck("bill_id", &billIDs)
+
+	if err := tx.Model(&models.Bill{}).
+		Where("id IN ?", billIDs).
+		Update("status", "settled").Error; err != nil {
+		return fmt.Errorf("failed to update bill statuses: %w", err)
+	}
+
+	return nil
+}
+
 // GetSettlement retrieves a settlement by ID
 func (s *SettlementService) GetSettlement(settlementID, userID uint) (*models.Settlement, error) {
 	var settlement models.Settlement
@@ -391,6 +1794,7 @@ func (s *SettlementService) GetSettlement(settlementID, userID uint) (*models.Se
 		Preload("Bills.PaidBy").
 		Preload("Transactions.FromUser").
 		Preload("Transactions.ToUser").
+		Preload("Transactions.Payments").
 		First(&settlement, settlementID).Error
 
 	if err != nil {
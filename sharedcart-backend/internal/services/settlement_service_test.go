@@ -0,0 +1,1224 @@
+package services
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/JacksonYuKe/sharedcart-backend/config"
+	"github.com/JacksonYuKe/sharedcart-backend/internal/models"
+	"github.com/shopspring/decimal"
+)
+
+func TestSplitByShareRatio(t *testing.T) {
+	tests := []struct {
+		name   string
+		amount string
+		owners []models.ItemOwner
+		want   map[uint]string
+	}{
+		{
+			name:   "Equal ratios",
+			amount: "10.00",
+			owners: []models.ItemOwner{
+				{UserID: 1, ShareRatio: decimal.NewFromInt(1)},
+				{UserID: 2, ShareRatio: decimal.NewFromInt(1)},
+			},
+			want: map[uint]string{1: "5", 2: "5"},
+		},
+		{
+			name:   "Two thirds of a pizza",
+			amount: "18.00",
+			owners: []models.ItemOwner{
+				{UserID: 1, ShareRatio: decimal.NewFromInt(2)},
+				{UserID: 2, ShareRatio: decimal.NewFromInt(1)},
+			},
+			want: map[uint]string{1: "12", 2: "6"},
+		},
+		{
+			name:   "Zero ratios fall back to equal split",
+			amount: "9.00",
+			owners: []models.ItemOwner{
+				{UserID: 1, ShareRatio: decimal.Zero},
+				{UserID: 2, ShareRatio: decimal.Zero},
+				{UserID: 3, ShareRatio: decimal.Zero},
+			},
+			want: map[uint]string{1: "3", 2: "3", 3: "3"},
+		},
+		{
+			name:   "No owners",
+			amount: "9.00",
+			owners: nil,
+			want:   map[uint]string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := splitByShareRatio(decimal.RequireFromString(tt.amount), tt.owners)
+			if len(got) != len(tt.want) {
+				t.Fatalf("splitByShareRatio() returned %d shares, want %d", len(got), len(tt.want))
+			}
+			for userID, want := range tt.want {
+				if !got[userID].Equal(decimal.RequireFromString(want)) {
+					t.Errorf("splitByShareRatio() user %d = %s, want %s", userID, got[userID], want)
+				}
+			}
+		})
+	}
+}
+
+func TestCalculateBillOwesWeightedItem(t *testing.T) {
+	members := []models.GroupMember{{UserID: 1}, {UserID: 2}}
+	balances := map[uint]*UserBalance{
+		1: {UserID: 1},
+		2: {UserID: 2},
+	}
+
+	bill := models.Bill{
+		TotalAmount: decimal.RequireFromString("30.00"),
+		PaidByID:    1,
+		Items: []models.BillItem{
+			{
+				Amount:    decimal.RequireFromString("24.00"),
+				Quantity:  decimal.NewFromInt(1),
+				SplitMode: "shares",
+				ItemOwners: []models.ItemOwner{
+					{UserID: 1, ShareRatio: decimal.NewFromInt(2)},
+					{UserID: 2, ShareRatio: decimal.NewFromInt(1)},
+				},
+			},
+			{
+				Amount:   decimal.RequireFromString("6.00"),
+				Quantity: decimal.NewFromInt(1),
+				IsShared: true,
+			},
+		},
+	}
+
+	calculateBillOwes(&bill, balances, members)
+
+	if want := decimal.RequireFromString("19"); !balances[1].Owes.Equal(want) {
+		t.Errorf("user 1 owes %s, want %s", balances[1].Owes, want)
+	}
+	if want := decimal.RequireFromString("11"); !balances[2].Owes.Equal(want) {
+		t.Errorf("user 2 owes %s, want %s", balances[2].Owes, want)
+	}
+}
+
+func TestAllocateByWeight(t *testing.T) {
+	tests := []struct {
+		name    string
+		total   string
+		weights map[uint]string
+		want    map[uint]string
+	}{
+		{
+			name:    "Ten dollars across three people",
+			total:   "10.00",
+			weights: map[uint]string{1: "1", 2: "1", 3: "1"},
+			want:    map[uint]string{1: "3.34", 2: "3.33", 3: "3.33"},
+		},
+		{
+			name:    "Largest remainder wins the leftover cent",
+			total:   "1.00",
+			weights: map[uint]string{1: "1", 2: "2"},
+			want:    map[uint]string{1: "0.33", 2: "0.67"},
+		},
+		{
+			name:    "Two leftover cents across three people",
+			total:   "0.05",
+			weights: map[uint]string{1: "1", 2: "1", 3: "1"},
+			want:    map[uint]string{1: "0.02", 2: "0.02", 3: "0.01"},
+		},
+		{
+			name:    "Exact split needs no adjustment",
+			total:   "9.00",
+			weights: map[uint]string{4: "1", 5: "2"},
+			want:    map[uint]string{4: "3", 5: "6"},
+		},
+		{
+			name:    "Zero weights allocate nothing",
+			total:   "9.00",
+			weights: map[uint]string{1: "0"},
+			want:    map[uint]string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			weights := make(map[uint]decimal.Decimal)
+			for userID, weight := range tt.weights {
+				weights[userID] = decimal.RequireFromString(weight)
+			}
+
+			got := allocateByWeight(decimal.RequireFromString(tt.total), weights, minorUnitPlaces)
+			if len(got) != len(tt.want) {
+				t.Fatalf("allocateByWeight() returned %d shares, want %d", len(got), len(tt.want))
+			}
+			for userID, want := range tt.want {
+				if !got[userID].Equal(decimal.RequireFromString(want)) {
+					t.Errorf("allocateByWeight() user %d = %s, want %s", userID, got[userID], want)
+				}
+			}
+		})
+	}
+}
+
+func TestSettlementReconcilesToTheCent(t *testing.T) {
+	s := &SettlementService{config: &config.SettlementConfig{ExactSolverMaxMembers: 12}}
+	members := []models.GroupMember{{UserID: 1}, {UserID: 2}, {UserID: 3}}
+	balances := map[uint]*UserBalance{
+		1: {UserID: 1},
+		2: {UserID: 2},
+		3: {UserID: 3},
+	}
+
+	bills := []models.Bill{
+		{
+			TotalAmount: decimal.RequireFromString("10.00"),
+			PaidByID:    1,
+			Items: []models.BillItem{
+				{Amount: decimal.RequireFromString("10.00"), Quantity: decimal.NewFromInt(1), IsShared: true},
+			},
+		},
+		{
+			TotalAmount: decimal.RequireFromString("20.00"),
+			PaidByID:    2,
+			Items: []models.BillItem{
+				{Amount: decimal.RequireFromString("6.67"), Quantity: decimal.NewFromInt(3), IsShared: true},
+			},
+		},
+		{
+			TotalAmount: decimal.RequireFromString("7.01"),
+			PaidByID:    3,
+			Items: []models.BillItem{
+				{
+					Amount:   decimal.RequireFromString("7.01"),
+					Quantity: decimal.NewFromInt(1),
+					ItemOwners: []models.ItemOwner{
+						{UserID: 1, ShareRatio: decimal.NewFromInt(1)},
+						{UserID: 2, ShareRatio: decimal.NewFromInt(1)},
+						{UserID: 3, ShareRatio: decimal.NewFromInt(1)},
+					},
+				},
+			},
+		},
+	}
+
+	totalAmount := decimal.Zero
+	for i := range bills {
+		totalAmount = totalAmount.Add(bills[i].TotalAmount)
+		balances[bills[i].PaidByID].Paid = balances[bills[i].PaidByID].Paid.Add(bills[i].TotalAmount)
+		calculateBillOwes(&bills[i], balances, members)
+	}
+
+	totalOwes := decimal.Zero
+	for _, balance := range balances {
+		if !balance.Owes.Equal(balance.Owes.Round(minorUnitPlaces)) {
+			t.Errorf("user %d owes %s, which is not rounded to the minor unit", balance.UserID, balance.Owes)
+		}
+		totalOwes = totalOwes.Add(balance.Owes)
+		balance.Balance = balance.Paid.Sub(balance.Owes)
+	}
+	if !totalOwes.Equal(totalAmount) {
+		t.Fatalf("sum of owes = %s, want %s", totalOwes, totalAmount)
+	}
+
+	// Applying the transactions must clear every balance exactly
+	remaining := make(map[uint]decimal.Decimal)
+	for userID, balance := range balances {
+		remaining[userID] = balance.Balance
+	}
+	transactions, _, err := s.optimizeTransactions(balances, "", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, trans := range transactions {
+		remaining[trans.FromUserID] = remaining[trans.FromUserID].Add(trans.Amount)
+		remaining[trans.ToUserID] = remaining[trans.ToUserID].Sub(trans.Amount)
+	}
+	for userID, amount := range remaining {
+		if !amount.IsZero() {
+			t.Errorf("user %d has %s left after settlement", userID, amount)
+		}
+	}
+}
+
+func TestCalculateBillOwesSharedAmongSubset(t *testing.T) {
+	members := []models.GroupMember{{UserID: 1}, {UserID: 2}, {UserID: 3}}
+	balances := map[uint]*UserBalance{
+		1: {UserID: 1},
+		2: {UserID: 2},
+		3: {UserID: 3},
+	}
+
+	// Everyone except the vegetarian (user 3) shares the meat
+	bill := models.Bill{
+		TotalAmount: decimal.RequireFromString("21.00"),
+		PaidByID:    1,
+		Items: []models.BillItem{
+			{
+				Amount:   decimal.RequireFromString("12.00"),
+				Quantity: decimal.NewFromInt(1),
+				IsShared: true,
+				ItemOwners: []models.ItemOwner{
+					{UserID: 1, ShareRatio: decimal.NewFromInt(1)},
+					{UserID: 2, ShareRatio: decimal.NewFromInt(1)},
+				},
+			},
+			{
+				Amount:   decimal.RequireFromString("9.00"),
+				Quantity: decimal.NewFromInt(1),
+				IsShared: true,
+			},
+		},
+	}
+
+	calculateBillOwes(&bill, balances, members)
+
+	want := map[uint]string{1: "9", 2: "9", 3: "3"}
+	for userID, amount := range want {
+		if !balances[userID].Owes.Equal(decimal.RequireFromString(amount)) {
+			t.Errorf("user %d owes %s, want %s", userID, balances[userID].Owes, amount)
+		}
+	}
+}
+
+func TestSplitItemModes(t *testing.T) {
+	owners := func(values ...models.ItemOwner) []models.ItemOwner { return values }
+
+	tests := []struct {
+		name string
+		item models.BillItem
+		want map[uint]string
+	}{
+		{
+			name: "Equal ignores ratios",
+			item: models.BillItem{
+				SplitMode: "equal",
+				ItemOwners: owners(
+					models.ItemOwner{UserID: 1, ShareRatio: decimal.NewFromInt(3)},
+					models.ItemOwner{UserID: 2, ShareRatio: decimal.NewFromInt(1)},
+				),
+			},
+			want: map[uint]string{1: "50", 2: "50"},
+		},
+		{
+			name: "Shares",
+			item: models.BillItem{
+				SplitMode: "shares",
+				ItemOwners: owners(
+					models.ItemOwner{UserID: 1, ShareRatio: decimal.NewFromInt(3)},
+					models.ItemOwner{UserID: 2, ShareRatio: decimal.NewFromInt(1)},
+				),
+			},
+			want: map[uint]string{1: "75", 2: "25"},
+		},
+		{
+			name: "Percent",
+			item: models.BillItem{
+				SplitMode: "percent",
+				ItemOwners: owners(
+					models.ItemOwner{UserID: 1, ShareRatio: decimal.NewFromInt(60)},
+					models.ItemOwner{UserID: 2, ShareRatio: decimal.NewFromInt(40)},
+				),
+			},
+			want: map[uint]string{1: "60", 2: "40"},
+		},
+		{
+			name: "Exact",
+			item: models.BillItem{
+				SplitMode: "exact",
+				ItemOwners: owners(
+					models.ItemOwner{UserID: 1, Amount: decimal.RequireFromString("82.50")},
+					models.ItemOwner{UserID: 2, Amount: decimal.RequireFromString("17.50")},
+				),
+			},
+			want: map[uint]string{1: "82.5", 2: "17.5"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := splitItem(&tt.item, decimal.NewFromInt(100))
+			for userID, want := range tt.want {
+				if !got[userID].Equal(decimal.RequireFromString(want)) {
+					t.Errorf("splitItem() user %d = %s, want %s", userID, got[userID], want)
+				}
+			}
+		})
+	}
+}
+
+func TestBillDateRange(t *testing.T) {
+	from := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2024, 3, 31, 0, 0, 0, 0, time.UTC)
+	start, end := billDateRange(from, to)
+
+	tests := []struct {
+		name     string
+		billDate time.Time
+		want     bool
+	}{
+		{"start of first day", time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), true},
+		{"during last day", time.Date(2024, 3, 31, 18, 45, 0, 0, time.UTC), true},
+		{"end of last day", time.Date(2024, 3, 31, 23, 59, 59, 0, time.UTC), true},
+		{"day before", time.Date(2024, 2, 29, 23, 59, 59, 0, time.UTC), false},
+		{"day after", time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := !tt.billDate.Before(start) && tt.billDate.Before(end)
+			if got != tt.want {
+				t.Errorf("bill dated %s in range = %v, want %v", tt.billDate, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBillPaidAmounts(t *testing.T) {
+	tests := []struct {
+		name string
+		bill models.Bill
+		want map[uint]string
+	}{
+		{
+			name: "Single payer",
+			bill: models.Bill{TotalAmount: decimal.RequireFromString("42.00"), PaidByID: 1},
+			want: map[uint]string{1: "42"},
+		},
+		{
+			name: "Two cards from two people",
+			bill: models.Bill{
+				TotalAmount: decimal.RequireFromString("150.00"),
+				PaidByID:    1,
+				Payers: []models.BillPayer{
+					{UserID: 1, Amount: decimal.RequireFromString("100.00")},
+					{UserID: 2, Amount: decimal.RequireFromString("50.00")},
+				},
+			},
+			want: map[uint]string{1: "100", 2: "50"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := billPaidAmounts(&tt.bill, tt.bill.TotalAmount)
+			if len(got) != len(tt.want) {
+				t.Fatalf("billPaidAmounts() returned %d payers, want %d", len(got), len(tt.want))
+			}
+			for userID, want := range tt.want {
+				if !got[userID].Equal(decimal.RequireFromString(want)) {
+					t.Errorf("billPaidAmounts() user %d = %s, want %s", userID, got[userID], want)
+				}
+			}
+		})
+	}
+}
+
+func TestCalculateBillOwesWithAdjustments(t *testing.T) {
+	members := []models.GroupMember{{UserID: 1}, {UserID: 2}, {UserID: 3}}
+	balances := map[uint]*UserBalance{
+		1: {UserID: 1},
+		2: {UserID: 2},
+		3: {UserID: 3},
+	}
+
+	bill := models.Bill{
+		TotalAmount: decimal.RequireFromString("48.00"),
+		PaidByID:    1,
+		Items: []models.BillItem{
+			{Amount: decimal.RequireFromString("30.00"), Quantity: decimal.NewFromInt(1), ItemOwners: []models.ItemOwner{{UserID: 1}}},
+			{Amount: decimal.RequireFromString("10.00"), Quantity: decimal.NewFromInt(1), ItemOwners: []models.ItemOwner{{UserID: 2}}},
+		},
+		Adjustments: []models.BillAdjustment{
+			{Type: "tax", Amount: decimal.RequireFromString("4.00")},
+			{Type: "tip", Amount: decimal.RequireFromString("6.00"), SplitEqually: true},
+			{Type: "discount", Amount: decimal.RequireFromString("2.00")},
+		},
+	}
+
+	calculateBillOwes(&bill, balances, members)
+
+	// User 3 had no items, so they pay no share of tax, tip or discount
+	want := map[uint]string{1: "34.5", 2: "13.5", 3: "0"}
+	for userID, amount := range want {
+		if !balances[userID].Owes.Equal(decimal.RequireFromString(amount)) {
+			t.Errorf("user %d owes %s, want %s", userID, balances[userID].Owes, amount)
+		}
+	}
+}
+
+func TestBillNetAmounts(t *testing.T) {
+	members := []models.GroupMember{{UserID: 1}, {UserID: 2}, {UserID: 3}}
+	bill := models.Bill{
+		TotalAmount: decimal.RequireFromString("10.00"),
+		PaidByID:    2,
+		Items: []models.BillItem{
+			{Amount: decimal.RequireFromString("10.00"), Quantity: decimal.NewFromInt(1), IsShared: true},
+		},
+	}
+
+	net := billNetAmounts(&bill, members)
+
+	want := map[uint]string{1: "-3.34", 2: "6.67", 3: "-3.33"}
+	sum := decimal.Zero
+	for userID, amount := range want {
+		if !net[userID].Equal(decimal.RequireFromString(amount)) {
+			t.Errorf("user %d net = %s, want %s", userID, net[userID], amount)
+		}
+		sum = sum.Add(net[userID])
+	}
+	if !sum.IsZero() {
+		t.Errorf("net amounts sum to %s, want 0", sum)
+	}
+}
+
+func TestExplainBillOwes(t *testing.T) {
+	members := []models.GroupMember{{UserID: 1}, {UserID: 2}, {UserID: 3}}
+
+	tests := []struct {
+		name  string
+		bill  models.Bill
+		kinds map[uint][]string // Line kinds per user, in order
+	}{
+		{
+			name: "shared item with rounding",
+			bill: models.Bill{
+				TotalAmount: decimal.RequireFromString("10.00"),
+				Items: []models.BillItem{
+					{ID: 1, Amount: decimal.RequireFromString("10.00"), Quantity: decimal.NewFromInt(1), IsShared: true},
+				},
+			},
+			kinds: map[uint][]string{1: {"item", "rounding"}, 2: {"item"}, 3: {"item"}},
+		},
+		{
+			name: "personal items with adjustments",
+			bill: models.Bill{
+				TotalAmount: decimal.RequireFromString("48.00"),
+				Items: []models.BillItem{
+					{ID: 1, Amount: decimal.RequireFromString("30.00"), Quantity: decimal.NewFromInt(1), ItemOwners: []models.ItemOwner{{UserID: 1}}},
+					{ID: 2, Amount: decimal.RequireFromString("10.00"), Quantity: decimal.NewFromInt(1), ItemOwners: []models.ItemOwner{{UserID: 2}}},
+				},
+				Adjustments: []models.BillAdjustment{
+					{Type: "tax", Amount: decimal.RequireFromString("4.00")},
+					{Type: "tip", Amount: decimal.RequireFromString("6.00"), SplitEqually: true},
+				},
+			},
+			kinds: map[uint][]string{1: {"item", "adjustment", "adjustment"}, 2: {"item", "adjustment", "adjustment"}},
+		},
+		{
+			name: "bill converted from another currency",
+			bill: models.Bill{
+				TotalAmount: decimal.RequireFromString("22.00"), // 20.00 at a rate of 1.1
+				Items: []models.BillItem{
+					{ID: 1, Amount: decimal.RequireFromString("15.00"), Quantity: decimal.NewFromInt(1), ItemOwners: []models.ItemOwner{{UserID: 1}, {UserID: 2}}},
+					{ID: 2, Amount: decimal.RequireFromString("5.00"), Quantity: decimal.NewFromInt(1), ItemOwners: []models.ItemOwner{{UserID: 3}}},
+				},
+			},
+			kinds: map[uint][]string{1: {"item"}, 2: {"item"}, 3: {"item"}},
+		},
+		{
+			name:  "bill without items",
+			bill:  models.Bill{TotalAmount: decimal.RequireFromString("9.00")},
+			kinds: map[uint][]string{1: {"bill"}, 2: {"bill"}, 3: {"bill"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			owes, _ := billOwes(&tt.bill, members)
+			lines := explainBillOwes(&tt.bill, members)
+
+			for _, member := range members {
+				userID := member.UserID
+
+				var kinds []string
+				sum := decimal.Zero
+				for _, line := range lines[userID] {
+					kinds = append(kinds, line.Kind)
+					sum = sum.Add(line.Amount)
+				}
+
+				if strings.Join(kinds, ",") != strings.Join(tt.kinds[userID], ",") {
+					t.Errorf("user %d lines = %v, want %v", userID, kinds, tt.kinds[userID])
+				}
+				if !sum.Equal(owes[userID]) {
+					t.Errorf("user %d lines add up to %s, want %s", userID, sum, owes[userID])
+				}
+			}
+		})
+	}
+}
+
+func TestCalculateBillOwesOnlyMembersOnBillDate(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2024, 3, d, 0, 0, 0, 0, time.UTC) }
+	left := day(10).Add(18 * time.Hour)
+
+	members := []models.GroupMember{
+		{UserID: 1, JoinedAt: day(1).Add(9 * time.Hour)},
+		{UserID: 2, JoinedAt: day(1), LeftAt: &left},
+		{UserID: 3, JoinedAt: day(15).Add(12 * time.Hour)},
+	}
+
+	shared := models.BillItem{Amount: decimal.RequireFromString("30.00"), Quantity: decimal.NewFromInt(1), IsShared: true}
+	sharedAndOwned := []models.BillItem{
+		{Amount: decimal.RequireFromString("20.00"), Quantity: decimal.NewFromInt(1), IsShared: true},
+		{Amount: decimal.RequireFromString("10.00"), Quantity: decimal.NewFromInt(1), ItemOwners: []models.ItemOwner{{UserID: 3}}},
+	}
+
+	tests := []struct {
+		name     string
+		billDate time.Time
+		items    []models.BillItem
+		want     map[uint]string
+	}{
+		{"before the newcomer joined", day(5), []models.BillItem{shared}, map[uint]string{1: "15", 2: "15", 3: "0"}},
+		{"on the day a member left", day(10), []models.BillItem{shared}, map[uint]string{1: "15", 2: "15", 3: "0"}},
+		{"after a member left", day(12), []models.BillItem{shared}, map[uint]string{1: "30", 2: "0", 3: "0"}},
+		{"on the day the newcomer joined", day(15), []models.BillItem{shared}, map[uint]string{1: "15", 2: "0", 3: "15"}},
+		{"before anyone joined", day(1).AddDate(0, -1, 0), []models.BillItem{shared}, map[uint]string{1: "0", 2: "30", 3: "0"}},
+		{"before anyone joined, with an owned item", day(1).AddDate(0, -1, 0), sharedAndOwned, map[uint]string{1: "0", 2: "10", 3: "20"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			balances := map[uint]*UserBalance{1: {UserID: 1}, 2: {UserID: 2}, 3: {UserID: 3}}
+			bill := models.Bill{
+				PaidByID:    2,
+				TotalAmount: decimal.RequireFromString("30.00"),
+				BillDate:    tt.billDate,
+				Items:       tt.items,
+			}
+
+			calculateBillOwes(&bill, balances, members)
+
+			for userID, amount := range tt.want {
+				if !balances[userID].Owes.Equal(decimal.RequireFromString(amount)) {
+					t.Errorf("user %d owes %s, want %s", userID, balances[userID].Owes, amount)
+				}
+			}
+		})
+	}
+}
+
+func TestOptimizeTransactionCounts(t *testing.T) {
+	tests := []struct {
+		name        string
+		balances    map[uint]string
+		wantGreedy  int
+		wantMinimal int
+	}{
+		{
+			name:        "subsets cancel out",
+			balances:    map[uint]string{1: "6.00", 2: "4.00", 3: "-4.00", 4: "-3.00", 5: "-3.00"},
+			wantGreedy:  4,
+			wantMinimal: 3,
+		},
+		{
+			name:        "independent pairs",
+			balances:    map[uint]string{1: "5.00", 2: "-5.00", 3: "2.50", 4: "-2.50"},
+			wantGreedy:  2,
+			wantMinimal: 2,
+		},
+		{
+			name:        "no smaller zero-sum subset",
+			balances:    map[uint]string{1: "10.00", 2: "-3.01", 3: "-6.99"},
+			wantGreedy:  2,
+			wantMinimal: 2,
+		},
+		{
+			name:        "already settled",
+			balances:    map[uint]string{1: "0.00", 2: "0.00"},
+			wantGreedy:  0,
+			wantMinimal: 0,
+		},
+	}
+
+	s := &SettlementService{config: &config.SettlementConfig{ExactSolverMaxMembers: 12}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for strategy, want := range map[string]int{"greedy": tt.wantGreedy, "minimal": tt.wantMinimal} {
+				balances := make(map[uint]*UserBalance)
+				for userID, amount := range tt.balances {
+					balances[userID] = &UserBalance{UserID: userID, Balance: decimal.RequireFromString(amount)}
+				}
+
+				transactions, used, err := s.optimizeTransactions(balances, strategy, nil)
+				if err != nil {
+					t.Fatalf("%s: unexpected error: %v", strategy, err)
+				}
+				if used != strategy {
+					t.Errorf("%s: used strategy %q", strategy, used)
+				}
+				if len(transactions) != want {
+					t.Errorf("%s: got %d transactions, want %d", strategy, len(transactions), want)
+				}
+
+				for _, trans := range transactions {
+					balances[trans.FromUserID].Balance = balances[trans.FromUserID].Balance.Add(trans.Amount)
+					balances[trans.ToUserID].Balance = balances[trans.ToUserID].Balance.Sub(trans.Amount)
+				}
+				for userID, balance := range balances {
+					if !balance.Balance.IsZero() {
+						t.Errorf("%s: user %d has %s left after settlement", strategy, userID, balance.Balance)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestOptimizeTransactionsFallsBackToGreedy(t *testing.T) {
+	balances := map[uint]*UserBalance{
+		1: {UserID: 1, Balance: decimal.RequireFromString("6.00")},
+		2: {UserID: 2, Balance: decimal.RequireFromString("4.00")},
+		3: {UserID: 3, Balance: decimal.RequireFromString("-4.00")},
+		4: {UserID: 4, Balance: decimal.RequireFromString("-3.00")},
+		5: {UserID: 5, Balance: decimal.RequireFromString("-3.00")},
+	}
+
+	s := &SettlementService{config: &config.SettlementConfig{ExactSolverMaxMembers: 4}}
+	transactions, used, err := s.optimizeTransactions(balances, "minimal", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if used != "greedy" {
+		t.Errorf("used strategy %q, want greedy above the size limit", used)
+	}
+	if len(transactions) != 4 {
+		t.Errorf("got %d transactions, want 4", len(transactions))
+	}
+}
+
+func TestConstrainedTransactions(t *testing.T) {
+	tests := []struct {
+		name        string
+		balances    map[uint]string
+		constraints paymentConstraints
+		want        map[[2]uint]string // {from, to} -> amount
+		wantErr     bool
+	}{
+		{
+			name:        "blocked pair is avoided",
+			balances:    map[uint]string{1: "5.00", 2: "10.00", 3: "-10.00", 4: "-5.00"},
+			constraints: paymentConstraints{4: {1: "block"}},
+			want:        map[[2]uint]string{{3, 1}: "5.00", {3, 2}: "5.00", {4, 2}: "5.00"},
+		},
+		{
+			name:        "preferred pair takes as much as possible",
+			balances:    map[uint]string{1: "10.00", 2: "5.00", 3: "-5.00", 4: "-10.00"},
+			constraints: paymentConstraints{3: {2: "prefer"}},
+			want:        map[[2]uint]string{{3, 2}: "5.00", {4, 1}: "10.00"},
+		},
+		{
+			name:        "blocks make settlement impossible",
+			balances:    map[uint]string{1: "6.00", 2: "4.00", 3: "-6.00", 4: "-4.00"},
+			constraints: paymentConstraints{3: {1: "block"}, 4: {2: "block"}},
+			wantErr:     true,
+		},
+		{
+			name:        "blocks leave another route",
+			balances:    map[uint]string{1: "5.00", 2: "5.00", 3: "-5.00", 4: "-5.00"},
+			constraints: paymentConstraints{3: {1: "block"}, 4: {2: "block"}},
+			want:        map[[2]uint]string{{3, 2}: "5.00", {4, 1}: "5.00"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var outstanding []*UserBalance
+			for userID := uint(1); userID <= uint(len(tt.balances)); userID++ {
+				outstanding = append(outstanding, &UserBalance{UserID: userID, Balance: decimal.RequireFromString(tt.balances[userID])})
+			}
+
+			transactions, err := constrainedTransactions(outstanding, tt.constraints)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got %v", transactions)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			got := make(map[[2]uint]string)
+			for _, trans := range transactions {
+				if tt.constraints[trans.FromUserID][trans.ToUserID] == "block" {
+					t.Errorf("user %d pays blocked user %d", trans.FromUserID, trans.ToUserID)
+				}
+				got[[2]uint{trans.FromUserID, trans.ToUserID}] = trans.Amount.StringFixed(2)
+			}
+			if len(got) != len(tt.want) {
+				t.Errorf("got transactions %v, want %v", got, tt.want)
+			}
+			for pair, amount := range tt.want {
+				if got[pair] != amount {
+					t.Errorf("%d pays %d %q, want %q", pair[0], pair[1], got[pair], amount)
+				}
+			}
+		})
+	}
+}
+
+func TestSetPaymentCurrency(t *testing.T) {
+	rateDate := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	rateOn := func(from, to string, date time.Time) (decimal.Decimal, time.Time, error) {
+		return decimal.RequireFromString("1.234567894"), rateDate, nil
+	}
+
+	t.Run("converts at the rounded rate", func(t *testing.T) {
+		transaction := models.SettlementTransaction{Amount: decimal.RequireFromString("10.00"), Currency: "USD"}
+		if err := setPaymentCurrency(&transaction, "EUR", rateOn); err != nil {
+			t.Fatalf("setPaymentCurrency() error = %v", err)
+		}
+		if transaction.PaymentCurrency != "EUR" {
+			t.Errorf("PaymentCurrency = %q, want EUR", transaction.PaymentCurrency)
+		}
+		if !transaction.ExchangeRate.Equal(decimal.RequireFromString("1.23456789")) {
+			t.Errorf("ExchangeRate = %s, want 1.23456789", transaction.ExchangeRate)
+		}
+		if !transaction.PaymentAmount.Equal(decimal.RequireFromString("12.35")) {
+			t.Errorf("PaymentAmount = %s, want 12.35", transaction.PaymentAmount)
+		}
+		if !transaction.RateDate.Equal(rateDate) {
+			t.Errorf("RateDate = %s, want %s", transaction.RateDate, rateDate)
+		}
+	})
+
+	t.Run("settlement currency clears the choice", func(t *testing.T) {
+		transaction := models.SettlementTransaction{Amount: decimal.RequireFromString("10.00"), Currency: "USD"}
+		if err := setPaymentCurrency(&transaction, "EUR", rateOn); err != nil {
+			t.Fatalf("setPaymentCurrency() error = %v", err)
+		}
+		if err := setPaymentCurrency(&transaction, "USD", rateOn); err != nil {
+			t.Fatalf("setPaymentCurrency() error = %v", err)
+		}
+		if transaction.PaymentCurrency != "" || transaction.PaymentAmount != nil || transaction.ExchangeRate != nil || transaction.RateDate != nil {
+			t.Errorf("payment currency not cleared: %+v", transaction)
+		}
+	})
+
+	t.Run("refused after a payment", func(t *testing.T) {
+		transaction := models.SettlementTransaction{
+			Amount:     decimal.RequireFromString("10.00"),
+			Currency:   "USD",
+			PaidAmount: decimal.RequireFromString("4.00"),
+		}
+		if err := setPaymentCurrency(&transaction, "EUR", rateOn); err == nil {
+			t.Error("setPaymentCurrency() accepted a transaction with payments")
+		}
+		if transaction.PaymentCurrency != "" {
+			t.Errorf("PaymentCurrency = %q, want it unchanged", transaction.PaymentCurrency)
+		}
+	})
+}
+
+func TestPaymentInSettlementCurrency(t *testing.T) {
+	rate := decimal.RequireFromString("0.33333333")
+	paymentAmount := decimal.RequireFromString("3.33")
+	transaction := models.SettlementTransaction{
+		Amount:          decimal.RequireFromString("10.00"),
+		RemainingAmount: decimal.RequireFromString("10.00"),
+		Currency:        "USD",
+		PaymentCurrency: "GBP",
+		PaymentAmount:   &paymentAmount,
+		ExchangeRate:    &rate,
+	}
+
+	tests := []struct {
+		name   string
+		amount string
+		want   string
+	}{
+		{"partial payment", "1.00", "3.00"},
+		{"rounds to the cent", "0.50", "1.50"},
+		{"full payment despite rounding", "3.33", "10.00"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := paymentInSettlementCurrency(&transaction, decimal.RequireFromString(tt.amount))
+			if !got.Equal(decimal.RequireFromString(tt.want)) {
+				t.Errorf("paymentInSettlementCurrency(%s) = %s, want %s", tt.amount, got, tt.want)
+			}
+		})
+	}
+
+	// Without a payment currency the amount is already in the settlement currency
+	plain := models.SettlementTransaction{Amount: decimal.RequireFromString("10.00"), RemainingAmount: decimal.RequireFromString("10.00"), Currency: "USD"}
+	if got := paymentInSettlementCurrency(&plain, decimal.RequireFromString("2.50")); !got.Equal(decimal.RequireFromString("2.50")) {
+		t.Errorf("paymentInSettlementCurrency() = %s, want 2.50", got)
+	}
+}
+
+func TestCanAcknowledge(t *testing.T) {
+	transaction := models.SettlementTransaction{FromUserID: 1, ToUserID: 2}
+
+	tests := []struct {
+		name                  string
+		userID                uint
+		receiverIsPlaceholder bool
+		isAdmin               bool
+		want                  bool
+	}{
+		{"receiver", 2, false, false, true},
+		{"payer for a member", 1, false, false, false},
+		{"admin for a member", 3, false, true, false},
+		{"payer for a placeholder", 1, true, false, true},
+		{"admin for a placeholder", 3, true, true, true},
+		{"other member for a placeholder", 3, true, false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := canAcknowledge(&transaction, tt.userID, tt.receiverIsPlaceholder, tt.isAdmin); got != tt.want {
+				t.Errorf("canAcknowledge() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecordPayment(t *testing.T) {
+	s := newTestServices(t)
+	alice := s.createUser(t, "alice")
+	bob := s.createUser(t, "bob")
+	carol := s.createUser(t, "carol")
+	group := s.createGroup(t, alice, bob, carol)
+
+	bill := s.createFinalizedBill(t, group.ID, alice, "30.00")
+	settlement := s.createSettlement(t, group.ID, alice, bill)
+	fromBob := transactionFrom(t, settlement, bob)
+	fromCarol := transactionFrom(t, settlement, carol)
+
+	// Nobody pays towards a settlement that hasn't been agreed on
+	if _, err := s.settlement.RecordPayment(settlement.ID, fromBob.ID, bob.ID, RecordPaymentRequest{Amount: decimal.NewFromInt(4)}); err == nil || err.Error() != "settlement is not open for payments" {
+		t.Fatalf("RecordPayment() on pending settlement error = %v, want settlement is not open for payments", err)
+	}
+	if err := s.settlement.ConfirmSettlement(settlement.ID, alice.ID); err != nil {
+		t.Fatalf("ConfirmSettlement() error = %v", err)
+	}
+
+	// Partial payment
+	transaction, err := s.settlement.RecordPayment(settlement.ID, fromBob.ID, bob.ID, RecordPaymentRequest{Amount: decimal.NewFromInt(4)})
+	if err != nil {
+		t.Fatalf("RecordPayment() error = %v", err)
+	}
+	if transaction.Status != "partially_paid" || !transaction.RemainingAmount.Equal(decimal.NewFromInt(6)) {
+		t.Errorf("after partial payment status = %s, remaining = %s, want partially_paid and 6", transaction.Status, transaction.RemainingAmount)
+	}
+	if got := s.ledgerBalance(t, group.ID, bob); !got.Equal(decimal.NewFromInt(-6)) {
+		t.Errorf("bob's balance after partial payment = %s, want -6", got)
+	}
+
+	// Only the payer records payments, and never more than is left
+	if _, err := s.settlement.RecordPayment(settlement.ID, fromBob.ID, carol.ID, RecordPaymentRequest{Amount: decimal.NewFromInt(1)}); err == nil || err.Error() != "only the payer can record a payment" {
+		t.Errorf("RecordPayment() by someone else error = %v, want only the payer can record a payment", err)
+	}
+	if _, err := s.settlement.RecordPayment(settlement.ID, fromBob.ID, bob.ID, RecordPaymentRequest{Amount: decimal.NewFromInt(7)}); err == nil || err.Error() != "payment exceeds remaining amount" {
+		t.Errorf("RecordPayment() overpayment error = %v, want payment exceeds remaining amount", err)
+	}
+
+	// Paying off the rest of every transaction completes the settlement
+	if _, err := s.settlement.RecordPayment(settlement.ID, fromBob.ID, bob.ID, RecordPaymentRequest{}); err != nil {
+		t.Fatalf("RecordPayment() remaining error = %v", err)
+	}
+	if got := s.settlementStatus(t, settlement.ID); got != "confirmed" {
+		t.Errorf("settlement status with a transaction left = %s, want confirmed", got)
+	}
+	transaction, err = s.settlement.RecordPayment(settlement.ID, fromCarol.ID, carol.ID, RecordPaymentRequest{})
+	if err != nil {
+		t.Fatalf("RecordPayment() error = %v", err)
+	}
+	if transaction.Status != "paid" || transaction.PaidAt == nil {
+		t.Errorf("transaction status = %s, paid at %v, want paid with a date", transaction.Status, transaction.PaidAt)
+	}
+	if got := s.settlementStatus(t, settlement.ID); got != "completed" {
+		t.Errorf("settlement status = %s, want completed", got)
+	}
+	for _, user := range []models.User{alice, bob, carol} {
+		if got := s.ledgerBalance(t, group.ID, user); !got.IsZero() {
+			t.Errorf("%s's balance after settling = %s, want 0", user.Name, got)
+		}
+	}
+}
+
+func TestAcknowledgePayment(t *testing.T) {
+	s := newTestServices(t)
+	alice := s.createUser(t, "alice")
+	bob := s.createUser(t, "bob")
+	group := s.createGroup(t, alice, bob)
+
+	bill := s.createFinalizedBill(t, group.ID, alice, "20.00")
+	settlement := s.createSettlement(t, group.ID, alice, bill)
+	fromBob := transactionFrom(t, settlement, bob)
+
+	if _, err := s.settlement.AcknowledgePayment(settlement.ID, fromBob.ID, alice.ID); err == nil || err.Error() != "settlement is not open for payments" {
+		t.Fatalf("AcknowledgePayment() on pending settlement error = %v, want settlement is not open for payments", err)
+	}
+	if err := s.settlement.ConfirmSettlement(settlement.ID, alice.ID); err != nil {
+		t.Fatalf("ConfirmSettlement() error = %v", err)
+	}
+	if _, err := s.settlement.AcknowledgePayment(settlement.ID, fromBob.ID, alice.ID); err == nil || err.Error() != "no payment to acknowledge" {
+		t.Errorf("AcknowledgePayment() before any payment error = %v, want no payment to acknowledge", err)
+	}
+
+	// The payment that completes the settlement can still be acknowledged
+	if _, err := s.settlement.RecordPayment(settlement.ID, fromBob.ID, bob.ID, RecordPaymentRequest{}); err != nil {
+		t.Fatalf("RecordPayment() error = %v", err)
+	}
+	if _, err := s.settlement.AcknowledgePayment(settlement.ID, fromBob.ID, bob.ID); err == nil || err.Error() != "only the receiver can acknowledge a payment" {
+		t.Errorf("AcknowledgePayment() by payer error = %v, want only the receiver can acknowledge a payment", err)
+	}
+	transaction, err := s.settlement.AcknowledgePayment(settlement.ID, fromBob.ID, alice.ID)
+	if err != nil {
+		t.Fatalf("AcknowledgePayment() error = %v", err)
+	}
+	if transaction.AcknowledgedAt == nil {
+		t.Error("transaction is not acknowledged")
+	}
+	for _, payment := range transaction.Payments {
+		if payment.AcknowledgedAt == nil {
+			t.Errorf("payment %d is not acknowledged", payment.ID)
+		}
+	}
+}
+
+func TestCancelSettlement(t *testing.T) {
+	s := newTestServices(t)
+	alice := s.createUser(t, "alice")
+	bob := s.createUser(t, "bob")
+	group := s.createGroup(t, alice, bob)
+
+	bill := s.createFinalizedBill(t, group.ID, alice, "20.00")
+	settlement := s.createSettlement(t, group.ID, alice, bill)
+
+	if err := s.settlement.CancelSettlement(settlement.ID, bob.ID, CancelSettlementRequest{Reason: "wrong"}); err == nil {
+		t.Error("CancelSettlement() by a member who didn't create it succeeded, want it refused")
+	}
+	if err := s.settlement.CancelSettlement(settlement.ID, alice.ID, CancelSettlementRequest{Reason: "missing a receipt"}); err != nil {
+		t.Fatalf("CancelSettlement() error = %v", err)
+	}
+
+	var cancelled models.Settlement
+	s.db.Preload("Transactions").First(&cancelled, settlement.ID)
+	if cancelled.Status != "cancelled" || cancelled.CancelReason != "missing a receipt" || cancelled.CancelledByID == nil {
+		t.Errorf("settlement = %s, reason %q, cancelled by %v, want cancelled with the reason and canceller", cancelled.Status, cancelled.CancelReason, cancelled.CancelledByID)
+	}
+	for _, transaction := range cancelled.Transactions {
+		if transaction.Status != "cancelled" {
+			t.Errorf("transaction %d status = %s, want cancelled", transaction.ID, transaction.Status)
+		}
+	}
+	if got := s.billStatus(t, bill.ID); got != "finalized" {
+		t.Errorf("bill status = %s, want finalized", got)
+	}
+	if err := s.settlement.CancelSettlement(settlement.ID, alice.ID, CancelSettlementRequest{Reason: "again"}); err == nil || err.Error() != "settlement is not pending" {
+		t.Errorf("CancelSettlement() twice error = %v, want settlement is not pending", err)
+	}
+
+	// The bill is free to be settled again
+	s.createSettlement(t, group.ID, alice, bill)
+}
+
+func TestVoidSettlement(t *testing.T) {
+	s := newTestServices(t)
+	alice := s.createUser(t, "alice")
+	bob := s.createUser(t, "bob")
+	group := s.createGroup(t, alice, bob)
+
+	bill := s.createFinalizedBill(t, group.ID, alice, "20.00")
+	settlement := s.createSettlement(t, group.ID, alice, bill)
+	fromBob := transactionFrom(t, settlement, bob)
+
+	if err := s.settlement.VoidSettlement(settlement.ID, alice.ID, CancelSettlementRequest{Reason: "too early"}); err == nil || err.Error() != "settlement is not confirmed" {
+		t.Errorf("VoidSettlement() on pending settlement error = %v, want settlement is not confirmed", err)
+	}
+	if err := s.settlement.ConfirmSettlement(settlement.ID, alice.ID); err != nil {
+		t.Fatalf("ConfirmSettlement() error = %v", err)
+	}
+	if got := s.billStatus(t, bill.ID); got != "settled" {
+		t.Fatalf("bill status after confirming = %s, want settled", got)
+	}
+
+	// A mistaken partial payment doesn't stop the settlement being voided
+	if _, err := s.settlement.RecordPayment(settlement.ID, fromBob.ID, bob.ID, RecordPaymentRequest{Amount: decimal.NewFromInt(4)}); err != nil {
+		t.Fatalf("RecordPayment() error = %v", err)
+	}
+	if err := s.settlement.VoidSettlement(settlement.ID, alice.ID, CancelSettlementRequest{Reason: "paid the wrong person"}); err != nil {
+		t.Fatalf("VoidSettlement() error = %v", err)
+	}
+
+	if got := s.settlementStatus(t, settlement.ID); got != "voided" {
+		t.Errorf("settlement status = %s, want voided", got)
+	}
+	if got := s.billStatus(t, bill.ID); got != "finalized" {
+		t.Errorf("bill status = %s, want finalized", got)
+	}
+
+	// The payment is taken back out of the balances, leaving what the bill says
+	if got := s.ledgerBalance(t, group.ID, bob); !got.Equal(decimal.NewFromInt(-10)) {
+		t.Errorf("bob's balance = %s, want -10", got)
+	}
+	if got := s.ledgerBalance(t, group.ID, alice); !got.Equal(decimal.NewFromInt(10)) {
+		t.Errorf("alice's balance = %s, want 10", got)
+	}
+}
+
+func TestBillConflictErrorMessage(t *testing.T) {
+	tests := []struct {
+		name     string
+		conflict BillConflictError
+		want     string
+	}{
+		{
+			name:     "Missing bills",
+			conflict: BillConflictError{MissingBillIDs: []uint{4, 9}},
+			want:     "bills cannot be settled: bills [4 9] are not in this group",
+		},
+		{
+			name: "Every reason",
+			conflict: BillConflictError{
+				MissingBillIDs:      []uint{9},
+				NotFinalizedBillIDs: []uint{2},
+				InSettlementBillIDs: []uint{3},
+			},
+			want: "bills cannot be settled: bills [9] are not in this group; bills [2] are not finalized; bills [3] are already in another settlement",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.conflict.Error(); got != tt.want {
+				t.Errorf("Error() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCalculateSettlementBillConflicts(t *testing.T) {
+	s := newTestServices(t)
+	alice := s.createUser(t, "alice")
+	bob := s.createUser(t, "bob")
+	group := s.createGroup(t, alice, bob)
+	otherGroup := s.createGroup(t, alice, bob)
+
+	finalized := s.createFinalizedBill(t, group.ID, alice, "20.00")
+	settled := s.createFinalizedBill(t, group.ID, alice, "8.00")
+	s.createSettlement(t, group.ID, alice, settled)
+	elsewhere := s.createFinalizedBill(t, otherGroup.ID, alice, "12.00")
+	pending, err := s.bill.CreateBill(alice.ID, CreateBillRequest{
+		GroupID:     group.ID,
+		Title:       "Snacks",
+		TotalAmount: decimal.NewFromInt(5),
+		Items:       []CreateBillItemRequest{{Name: "Crisps", Amount: decimal.NewFromInt(5), IsShared: true}},
+	})
+	if err != nil {
+		t.Fatalf("CreateBill() error = %v", err)
+	}
+	const unknownBillID = 999
+
+	_, err = s.settlement.CalculateSettlement(alice.ID, CalculateSettlementRequest{
+		GroupID: group.ID,
+		BillIDs: []uint{unknownBillID, finalized.ID, settled.ID, elsewhere.ID, pending.ID},
+	})
+
+	var conflict *BillConflictError
+	if !errors.As(err, &conflict) {
+		t.Fatalf("CalculateSettlement() error = %v, want a BillConflictError", err)
+	}
+	if want := []uint{elsewhere.ID, unknownBillID}; !slices.Equal(conflict.MissingBillIDs, want) {
+		t.Errorf("MissingBillIDs = %v, want %v", conflict.MissingBillIDs, want)
+	}
+	if want := []uint{pending.ID}; !slices.Equal(conflict.NotFinalizedBillIDs, want) {
+		t.Errorf("NotFinalizedBillIDs = %v, want %v", conflict.NotFinalizedBillIDs, want)
+	}
+	if want := []uint{settled.ID}; !slices.Equal(conflict.InSettlementBillIDs, want) {
+		t.Errorf("InSettlementBillIDs = %v, want %v", conflict.InSettlementBillIDs, want)
+	}
+}
+
+func TestGetGroupBalancesWithImpossiblePreferences(t *testing.T) {
+	s := newTestServices(t)
+	alice := s.createUser(t, "alice")
+	bob := s.createUser(t, "bob")
+	carol := s.createUser(t, "carol")
+	group := s.createGroup(t, alice, bob, carol)
+	bill := s.createFinalizedBill(t, group.ID, alice, "30.00")
+
+	// Alice is the only one owed anything, so bob refusing to pay her rules out every plan
+	if _, err := s.preference.SetPaymentPreference(group.ID, bob.ID, SetPaymentPreferenceRequest{TargetUserID: alice.ID, Kind: "block"}); err != nil {
+		t.Fatalf("SetPaymentPreference() error = %v", err)
+	}
+
+	result, err := s.settlement.GetGroupBalances(group.ID, carol.ID)
+	if err != nil {
+		t.Fatalf("GetGroupBalances() error = %v", err)
+	}
+	if result.PlanError != "payment preferences make settlement impossible" {
+		t.Errorf("PlanError = %q, want payment preferences make settlement impossible", result.PlanError)
+	}
+	if len(result.Transactions) != 0 {
+		t.Errorf("got %d transactions, want none", len(result.Transactions))
+	}
+	want := map[uint]string{alice.ID: "20", bob.ID: "-10", carol.ID: "-10"}
+	for _, balance := range result.Balances {
+		if !balance.Balance.Equal(decimal.RequireFromString(want[balance.UserID])) {
+			t.Errorf("user %d balance = %s, want %s", balance.UserID, balance.Balance, want[balance.UserID])
+		}
+	}
+
+	// Creating a settlement still needs a plan
+	_, err = s.settlement.CalculateSettlement(alice.ID, CalculateSettlementRequest{GroupID: group.ID, BillIDs: []uint{bill.ID}})
+	if err == nil || err.Error() != "payment preferences make settlement impossible" {
+		t.Errorf("CalculateSettlement() error = %v, want payment preferences make settlement impossible", err)
+	}
+}
+
+func TestSetPaymentCurrencyService(t *testing.T) {
+	s := newTestServices(t)
+	alice := s.createUser(t, "alice")
+	bob := s.createUser(t, "bob")
+	group := s.createGroup(t, alice, bob)
+	if _, err := s.rates.SetExchangeRates([]ExchangeRateRequest{
+		{BaseCurrency: "USD", QuoteCurrency: "EUR", Rate: decimal.RequireFromString("0.90"), EffectiveDate: "2024-01-01"},
+	}); err != nil {
+		t.Fatalf("SetExchangeRates() error = %v", err)
+	}
+
+	bill := s.createFinalizedBill(t, group.ID, alice, "20.00")
+	settlement := s.createSettlement(t, group.ID, alice, bill)
+	fromBob := transactionFrom(t, settlement, bob)
+	if err := s.settlement.ConfirmSettlement(settlement.ID, alice.ID); err != nil {
+		t.Fatalf("ConfirmSettlement() error = %v", err)
+	}
+
+	if _, err := s.settlement.SetPaymentCurrency(settlement.ID, fromBob.ID, alice.ID, SetPaymentCurrencyRequest{Currency: "EUR"}); err == nil || err.Error() != "only the payer can choose the payment currency" {
+		t.Errorf("SetPaymentCurrency() by receiver error = %v, want only the payer can choose the payment currency", err)
+	}
+
+	transaction, err := s.settlement.SetPaymentCurrency(settlement.ID, fromBob.ID, bob.ID, SetPaymentCurrencyRequest{Currency: "EUR"})
+	if err != nil {
+		t.Fatalf("SetPaymentCurrency() error = %v", err)
+	}
+	if transaction.PaymentCurrency != "EUR" || transaction.PaymentAmount == nil || !transaction.PaymentAmount.Equal(decimal.NewFromInt(9)) {
+		t.Fatalf("payment = %s %v, want EUR 9", transaction.PaymentCurrency, transaction.PaymentAmount)
+	}
+
+	// Paying part of it in euros fixes the currency
+	if _, err := s.settlement.RecordPayment(settlement.ID, fromBob.ID, bob.ID, RecordPaymentRequest{Amount: decimal.RequireFromString("4.50")}); err != nil {
+		t.Fatalf("RecordPayment() error = %v", err)
+	}
+	if got := s.ledgerBalance(t, group.ID, bob); !got.Equal(decimal.NewFromInt(-5)) {
+		t.Errorf("bob's balance after paying 4.50 EUR = %s USD, want -5", got)
+	}
+	_, err = s.settlement.SetPaymentCurrency(settlement.ID, fromBob.ID, bob.ID, SetPaymentCurrencyRequest{Currency: "USD"})
+	if err == nil || err.Error() != "cannot change payment currency after payments are recorded" {
+		t.Errorf("SetPaymentCurrency() after a payment error = %v, want cannot change payment currency after payments are recorded", err)
+	}
+}
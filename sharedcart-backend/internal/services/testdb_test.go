@@ -0,0 +1,198 @@
+package services
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/JacksonYuKe/sharedcart-backend/config"
+	"github.com/JacksonYuKe/sharedcart-backend/internal/database"
+	"github.com/JacksonYuKe/sharedcart-backend/internal/models"
+	"github.com/shopspring/decimal"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// testServices bundles the services wired together as the routes wire them
+type testServices struct {
+	db         *gorm.DB
+	ledger     *LedgerService
+	group      *GroupService
+	rates      *ExchangeRateService
+	bill       *BillService
+	preference *PaymentPreferenceService
+	settlement *SettlementService
+	debtOffset *DebtOffsetService
+	invitation *InvitationService
+	auth       *AuthService
+}
+
+// newTestServices opens a fresh SQLite database with every model migrated and builds the services
+// on top of it. SQLite ignores row locks, so these tests cover behaviour rather than concurrency.
+func newTestServices(t *testing.T) *testServices {
+	t.Helper()
+
+	dsn := "file:" + filepath.Join(t.TempDir(), "test.db") + "?_journal_mode=WAL&_busy_timeout=5000"
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("failed to open test database: %v", err)
+	}
+	if err := db.AutoMigrate(models.GetAllModels()...); err != nil {
+		t.Fatalf("failed to migrate test database: %v", err)
+	}
+
+	// Services pick up the database when they are created
+	previous := database.DB
+	database.DB = db
+	t.Cleanup(func() {
+		database.DB = previous
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+
+	jwtConfig := &config.JWTConfig{Secret: "test-secret", ExpiryHours: 1, InviteExpiryHours: 24}
+	s := &testServices{db: db}
+	s.ledger = NewLedgerService()
+	s.group = NewGroupService(s.ledger)
+	s.rates = NewExchangeRateService()
+	s.bill = NewBillService(s.group, s.ledger, s.rates)
+	s.preference = NewPaymentPreferenceService(s.group)
+	s.settlement = NewSettlementService(&config.SettlementConfig{ExactSolverMaxMembers: 12}, s.group, s.bill, s.ledger, s.preference, s.rates)
+	s.debtOffset = NewDebtOffsetService(s.group, s.settlement, s.ledger)
+	s.invitation = NewInvitationService(jwtConfig, s.group)
+	s.auth = NewAuthService(jwtConfig)
+	return s
+}
+
+// createUser adds a user with an email derived from their name
+func (s *testServices) createUser(t *testing.T, name string) models.User {
+	t.Helper()
+	user := models.User{Email: fmt.Sprintf("%s@example.com", name), Password: "hashed", Name: name, IsActive: true}
+	if err := s.db.Create(&user).Error; err != nil {
+		t.Fatalf("failed to create user %s: %v", name, err)
+	}
+	return user
+}
+
+// createPlaceholder adds a participant without an account
+func (s *testServices) createPlaceholder(t *testing.T, name string) models.User {
+	t.Helper()
+	user := models.User{Email: fmt.Sprintf("placeholder-%s@placeholder.invalid", name), Password: "!", Name: name, IsPlaceholder: true}
+	if err := s.db.Create(&user).Error; err != nil {
+		t.Fatalf("failed to create placeholder %s: %v", name, err)
+	}
+	return user
+}
+
+// createGroup creates a group administered by admin, with the other users as members who joined on the same day
+func (s *testServices) createGroup(t *testing.T, admin models.User, members ...models.User) models.Group {
+	t.Helper()
+	group, err := s.group.CreateGroup(admin.ID, CreateGroupRequest{Name: "Flat", Currency: "USD"})
+	if err != nil {
+		t.Fatalf("failed to create group: %v", err)
+	}
+	for _, member := range members {
+		s.addMember(t, group.ID, member, "member", time.Time{})
+	}
+	return *group
+}
+
+// addMember adds a user to a group, joining at joinedAt unless it is zero
+func (s *testServices) addMember(t *testing.T, groupID uint, user models.User, role string, joinedAt time.Time) {
+	t.Helper()
+	member := models.GroupMember{GroupID: groupID, UserID: user.ID, Role: role, JoinedAt: joinedAt}
+	if joinedAt.IsZero() {
+		member.JoinedAt = time.Now()
+	}
+	if err := s.db.Create(&member).Error; err != nil {
+		t.Fatalf("failed to add %s to group: %v", user.Name, err)
+	}
+}
+
+// createFinalizedBill records a bill paid by payer with one item split equally among the owners,
+// or shared among every member when no owners are given, and finalizes it
+func (s *testServices) createFinalizedBill(t *testing.T, groupID uint, payer models.User, amount string, owners ...models.User) models.Bill {
+	t.Helper()
+	item := CreateBillItemRequest{Name: "Groceries", Amount: decimal.RequireFromString(amount), IsShared: len(owners) == 0}
+	for _, owner := range owners {
+		item.OwnerIDs = append(item.OwnerIDs, owner.ID)
+	}
+
+	bill, err := s.bill.CreateBill(payer.ID, CreateBillRequest{
+		GroupID:     groupID,
+		Title:       "Groceries",
+		TotalAmount: decimal.RequireFromString(amount),
+		Items:       []CreateBillItemRequest{item},
+	})
+	if err != nil {
+		t.Fatalf("failed to create bill: %v", err)
+	}
+	if err := s.bill.FinalizeBill(bill.ID, payer.ID); err != nil {
+		t.Fatalf("failed to finalize bill: %v", err)
+	}
+	return *bill
+}
+
+// createSettlement calculates and saves a settlement over the given bills
+func (s *testServices) createSettlement(t *testing.T, groupID uint, creator models.User, bills ...models.Bill) *models.Settlement {
+	t.Helper()
+	req := CalculateSettlementRequest{GroupID: groupID}
+	for _, bill := range bills {
+		req.BillIDs = append(req.BillIDs, bill.ID)
+	}
+
+	result, err := s.settlement.CalculateSettlement(creator.ID, req)
+	if err != nil {
+		t.Fatalf("failed to calculate settlement: %v", err)
+	}
+	settlement, err := s.settlement.CreateSettlement(creator.ID, req, result)
+	if err != nil {
+		t.Fatalf("failed to create settlement: %v", err)
+	}
+	return settlement
+}
+
+// transactionFrom returns the settlement transaction paid by the given user
+func transactionFrom(t *testing.T, settlement *models.Settlement, payer models.User) models.SettlementTransaction {
+	t.Helper()
+	for _, transaction := range settlement.Transactions {
+		if transaction.FromUserID == payer.ID {
+			return transaction
+		}
+	}
+	t.Fatalf("settlement %d has no transaction from %s", settlement.ID, payer.Name)
+	return models.SettlementTransaction{}
+}
+
+// ledgerBalance returns a member's running balance in a group
+func (s *testServices) ledgerBalance(t *testing.T, groupID uint, user models.User) decimal.Decimal {
+	t.Helper()
+	var balance models.GroupBalance
+	if err := s.db.Where("group_id = ? AND user_id = ?", groupID, user.ID).Limit(1).Find(&balance).Error; err != nil {
+		t.Fatalf("failed to get balance of %s: %v", user.Name, err)
+	}
+	return balance.Balance
+}
+
+// settlementStatus reloads a settlement's status
+func (s *testServices) settlementStatus(t *testing.T, settlementID uint) string {
+	t.Helper()
+	var settlement models.Settlement
+	if err := s.db.Select("status").First(&settlement, settlementID).Error; err != nil {
+		t.Fatalf("failed to get settlement %d: %v", settlementID, err)
+	}
+	return settlement.Status
+}
+
+// billStatus reloads a bill's status
+func (s *testServices) billStatus(t *testing.T, billID uint) string {
+	t.Helper()
+	var bill models.Bill
+	if err := s.db.Select("status").First(&bill, billID).Error; err != nil {
+		t.Fatalf("failed to get bill %d: %v", billID, err)
+	}
+	return bill.Status
+}
@@ -0,0 +1,194 @@
+// Package pdf writes simple text-only PDF documents using the fonts built into every PDF reader,
+// so documents can be generated without any external tools or font files.
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+const (
+	pageWidth  = 612.0 // US Letter, in points
+	pageHeight = 792.0
+	margin     = 50.0
+
+	bodySize    = 10.0
+	bodyLeading = 14.0
+
+	// avgCharWidth approximates Helvetica's average glyph width as a fraction of the font size
+	avgCharWidth = 0.52
+)
+
+type font int
+
+const (
+	regular font = iota
+	bold
+)
+
+type textItem struct {
+	x, y float64
+	size float64
+	font font
+	text string
+}
+
+// Document is a text-only PDF laid out from the top of the first page down
+type Document struct {
+	pages [][]textItem
+	y     float64
+}
+
+// New creates an empty document
+func New() *Document {
+	d := &Document{}
+	d.newPage()
+	return d
+}
+
+// Title adds a large bold line
+func (d *Document) Title(text string) {
+	d.advance(24)
+	d.add(margin, 18, bold, text)
+}
+
+// Heading adds a bold section heading with some space above it
+func (d *Document) Heading(text string) {
+	d.advance(26)
+	d.add(margin, 13, bold, text)
+}
+
+// Text adds a line of body text
+func (d *Document) Text(text string) {
+	d.advance(bodyLeading)
+	d.add(margin, bodySize, regular, text)
+}
+
+// Table adds a bold header row followed by the rows, with each column as wide as given in points.
+// Cells that don't fit are shortened, and the header is repeated when the table runs onto a new page.
+func (d *Document) Table(widths []float64, header []string, rows [][]string) {
+	d.advance(bodyLeading)
+	d.row(widths, header, bold)
+	for _, row := range rows {
+		if d.y-bodyLeading < margin {
+			d.newPage()
+			d.advance(bodyLeading)
+			d.row(widths, header, bold)
+		}
+		d.advance(bodyLeading)
+		d.row(widths, row, regular)
+	}
+}
+
+// Bytes renders the document
+func (d *Document) Bytes() []byte {
+	var buf bytes.Buffer
+	var offsets []int
+	writeObject := func(body string) int {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+		return len(offsets)
+	}
+
+	buf.WriteString("%PDF-1.4\n")
+
+	// Objects 1-4 are fixed; each page then adds a content stream followed by the page itself
+	kids := make([]string, len(d.pages))
+	for i := range d.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 6+2*i)
+	}
+	writeObject("<< /Type /Catalog /Pages 2 0 R >>")
+	writeObject(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+	writeObject("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	writeObject("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+
+	for i, page := range d.pages {
+		footer := textItem{x: margin, y: margin / 2, size: 8, font: regular, text: fmt.Sprintf("Page %d of %d", i+1, len(d.pages))}
+
+		var content strings.Builder
+		for _, item := range append(page, footer) {
+			fmt.Fprintf(&content, "BT /F%d %.1f Tf %.2f %.2f Td (%s) Tj ET\n", item.font+1, item.size, item.x, item.y, encodeText(item.text))
+		}
+		stream := strings.TrimSuffix(content.String(), "\n")
+
+		contentID := writeObject(fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(stream), stream))
+		writeObject(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.0f %.0f] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+			pageWidth, pageHeight, contentID))
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	return buf.Bytes()
+}
+
+func (d *Document) newPage() {
+	d.pages = append(d.pages, nil)
+	d.y = pageHeight - margin
+}
+
+// advance moves down by one line, starting a new page when the current one is full
+func (d *Document) advance(height float64) {
+	if d.y-height < margin {
+		d.newPage()
+	}
+	d.y -= height
+}
+
+func (d *Document) add(x, size float64, f font, text string) {
+	page := &d.pages[len(d.pages)-1]
+	*page = append(*page, textItem{x: x, y: d.y, size: size, font: f, text: text})
+}
+
+func (d *Document) row(widths []float64, cells []string, f font) {
+	x := margin
+	for i, cell := range cells {
+		if i >= len(widths) {
+			break
+		}
+		d.add(x, bodySize, f, fit(cell, widths[i]-4, bodySize))
+		x += widths[i]
+	}
+}
+
+// fit shortens text that would overflow the given width
+func fit(text string, width, size float64) string {
+	maxChars := int(width / (size * avgCharWidth))
+	runes := []rune(text)
+	if len(runes) <= maxChars {
+		return text
+	}
+	if maxChars <= 3 {
+		return string(runes[:max(maxChars, 0)])
+	}
+	return string(runes[:maxChars-3]) + "..."
+}
+
+// encodeText escapes text for a PDF string in WinAnsiEncoding. Characters outside Latin-1,
+// apart from the euro sign, are replaced with a question mark.
+func encodeText(text string) string {
+	var b strings.Builder
+	for _, r := range text {
+		switch {
+		case r == '\\' || r == '(' || r == ')':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r == '€':
+			b.WriteString(`\200`)
+		case r < 0x20:
+			b.WriteByte(' ')
+		case r < 0x80:
+			b.WriteRune(r)
+		case r >= 0xA0 && r <= 0xFF:
+			fmt.Fprintf(&b, `\%03o`, r)
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,92 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestDocumentStructure(t *testing.T) {
+	doc := New()
+	doc.Title("Settlement #1")
+	doc.Text("Total (USD): 12.50")
+
+	rows := make([][]string, 120)
+	for i := range rows {
+		rows[i] = []string{strconv.Itoa(i), "Groceries"}
+	}
+	doc.Table([]float64{60, 200}, []string{"#", "Bill"}, rows)
+
+	out := doc.Bytes()
+
+	if !bytes.HasPrefix(out, []byte("%PDF-1.4\n")) {
+		t.Fatalf("missing PDF header")
+	}
+	if !bytes.HasSuffix(out, []byte("%%EOF\n")) {
+		t.Fatalf("missing EOF marker")
+	}
+
+	pages := bytes.Count(out, []byte("/Type /Page "))
+	if pages < 2 {
+		t.Errorf("got %d pages, want the table to run onto a second page", pages)
+	}
+	if !bytes.Contains(out, []byte(fmt.Sprintf("/Count %d", pages))) {
+		t.Errorf("page count doesn't match the number of pages")
+	}
+
+	// Every xref entry must point at the start of its object
+	match := regexp.MustCompile(`startxref\n(\d+)\n`).FindSubmatch(out)
+	if match == nil {
+		t.Fatalf("missing startxref")
+	}
+	xref, _ := strconv.Atoi(string(match[1]))
+	if !bytes.HasPrefix(out[xref:], []byte("xref\n")) {
+		t.Fatalf("startxref doesn't point at the xref table")
+	}
+	lines := strings.Split(string(out[xref:]), "\n")
+	for i, line := range lines[3:] {
+		if !strings.HasSuffix(line, " n ") {
+			break
+		}
+		offset, _ := strconv.Atoi(line[:10])
+		want := fmt.Sprintf("%d 0 obj", i+1)
+		if !bytes.HasPrefix(out[offset:], []byte(want)) {
+			t.Errorf("xref entry %d points at %q, want %q", i+1, out[offset:offset+len(want)], want)
+		}
+	}
+}
+
+func TestEncodeText(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"plain", "Milk 2L", "Milk 2L"},
+		{"parentheses and backslash", `a(b)\c`, `a\(b\)\\c`},
+		{"latin-1", "Café", `Caf\351`},
+		{"euro sign", "€5", `\2005`},
+		{"outside latin-1", "寿司", "??"},
+		{"control characters", "a\tb", "a b"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := encodeText(tt.in); got != tt.want {
+				t.Errorf("encodeText(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFit(t *testing.T) {
+	if got := fit("Bread", 100, 10); got != "Bread" {
+		t.Errorf("fit() shortened text that fits: %q", got)
+	}
+	if got := fit("A very long item description", 50, 10); got != "A very..." {
+		t.Errorf("fit() = %q, want %q", got, "A very...")
+	}
+}
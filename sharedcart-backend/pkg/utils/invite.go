@@ -0,0 +1,97 @@
+package utils
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// inviteAudience marks tokens that can only be used to answer a group invitation
+const inviteAudience = "group-invitation"
+
+// InviteClaims represents the claims in a group invitation token
+type InviteClaims struct {
+	InvitationID uint   `json:"invitation_id"`
+	GroupID      uint   `json:"group_id"`
+	Email        string `json:"email"`
+	// Explicitly define registered claims fields instead of embedding
+	ExpiresAt *jwt.NumericDate `json:"exp,omitempty"`
+	IssuedAt  *jwt.NumericDate `json:"iat,omitempty"`
+	NotBefore *jwt.NumericDate `json:"nbf,omitempty"`
+	Issuer    string           `json:"iss,omitempty"`
+	Subject   string           `json:"sub,omitempty"`
+	Audience  jwt.ClaimStrings `json:"aud,omitempty"`
+}
+
+// GetExpirationTime implements jwt.Claims
+func (c *InviteClaims) GetExpirationTime() (*jwt.NumericDate, error) {
+	return c.ExpiresAt, nil
+}
+
+// GetIssuedAt implements jwt.Claims
+func (c *InviteClaims) GetIssuedAt() (*jwt.NumericDate, error) {
+	return c.IssuedAt, nil
+}
+
+// GetNotBefore implements jwt.Claims
+func (c *InviteClaims) GetNotBefore() (*jwt.NumericDate, error) {
+	return c.NotBefore, nil
+}
+
+// GetIssuer implements jwt.Claims
+func (c *InviteClaims) GetIssuer() (string, error) {
+	return c.Issuer, nil
+}
+
+// GetSubject implements jwt.Claims
+func (c *InviteClaims) GetSubject() (string, error) {
+	return c.Subject, nil
+}
+
+// GetAudience implements jwt.Claims
+func (c *InviteClaims) GetAudience() (jwt.ClaimStrings, error) {
+	return c.Audience, nil
+}
+
+// GenerateInviteToken generates a signed token for a group invitation that is valid until expiresAt
+func GenerateInviteToken(invitationID, groupID uint, email, secret string, expiresAt time.Time) (string, error) {
+	claims := &InviteClaims{
+		InvitationID: invitationID,
+		GroupID:      groupID,
+		Email:        email,
+		ExpiresAt:    jwt.NewNumericDate(expiresAt),
+		IssuedAt:     jwt.NewNumericDate(time.Now()),
+		NotBefore:    jwt.NewNumericDate(time.Now()),
+		Issuer:       "sharedcart",
+		Audience:     jwt.ClaimStrings{inviteAudience},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(inviteKey(secret))
+}
+
+// ValidateInviteToken validates and parses a group invitation token
+func ValidateInviteToken(tokenString, secret string) (*InviteClaims, error) {
+	token, err := jwt.ParseWithClaims(tokenString, &InviteClaims{}, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return inviteKey(secret), nil
+	}, jwt.WithAudience(inviteAudience))
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse token: %w", err)
+	}
+
+	if claims, ok := token.Claims.(*InviteClaims); ok && token.Valid {
+		return claims, nil
+	}
+
+	return nil, fmt.Errorf("invalid token")
+}
+
+// inviteKey derives the key invitation tokens are signed with, so they can never pass as login tokens
+func inviteKey(secret string) []byte {
+	return []byte(secret + ":" + inviteAudience)
+}
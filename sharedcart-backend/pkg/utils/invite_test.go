@@ -0,0 +1,84 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGenerateInviteToken(t *testing.T) {
+	secret := "test-secret-key"
+
+	token, err := GenerateInviteToken(7, 3, "friend@example.com", secret, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("GenerateInviteToken() error = %v", err)
+	}
+
+	claims, err := ValidateInviteToken(token, secret)
+	if err != nil {
+		t.Fatalf("Failed to validate generated token: %v", err)
+	}
+
+	if claims.InvitationID != 7 {
+		t.Errorf("InvitationID = %v, want %v", claims.InvitationID, 7)
+	}
+	if claims.GroupID != 3 {
+		t.Errorf("GroupID = %v, want %v", claims.GroupID, 3)
+	}
+	if claims.Email != "friend@example.com" {
+		t.Errorf("Email = %v, want %v", claims.Email, "friend@example.com")
+	}
+}
+
+func TestValidateInviteToken(t *testing.T) {
+	secret := "test-secret-key"
+
+	validToken, _ := GenerateInviteToken(1, 1, "friend@example.com", secret, time.Now().Add(time.Hour))
+	expiredToken, _ := GenerateInviteToken(1, 1, "friend@example.com", secret, time.Now().Add(-time.Hour))
+	loginToken, _ := GenerateJWT(1, "friend@example.com", "Friend", secret, 24)
+
+	tests := []struct {
+		name    string
+		token   string
+		secret  string
+		wantErr bool
+	}{
+		{
+			name:    "valid token",
+			token:   validToken,
+			secret:  secret,
+			wantErr: false,
+		},
+		{
+			name:    "expired token",
+			token:   expiredToken,
+			secret:  secret,
+			wantErr: true,
+		},
+		{
+			name:    "wrong secret",
+			token:   validToken,
+			secret:  "wrong-secret",
+			wantErr: true,
+		},
+		{
+			name:    "login token",
+			token:   loginToken,
+			secret:  secret,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ValidateInviteToken(tt.token, tt.secret)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateInviteToken() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	// Invitation tokens must not work as login tokens either
+	if _, err := ValidateJWT(validToken, secret); err == nil {
+		t.Error("ValidateJWT() accepted an invitation token")
+	}
+}